@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,9 +17,11 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/samualhalder/go-social/internal/auth"
 	"github.com/samualhalder/go-social/internal/mailer"
+	"github.com/samualhalder/go-social/internal/ranking"
 	"github.com/samualhalder/go-social/internal/ratelimiter"
 	"github.com/samualhalder/go-social/internal/store"
 	"github.com/samualhalder/go-social/internal/store/cache"
+	"github.com/samualhalder/go-social/internal/timeline"
 	"go.uber.org/zap"
 
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -30,8 +33,14 @@ type application struct {
 	cacheStorage  cache.Store
 	logger        *zap.SugaredLogger
 	mailer        mailer.Client
-	authenticator *auth.JWTAuthenticator
+	authenticator auth.Authenticator
 	ratelimiter   ratelimiter.Limiter
+	oidcProviders map[string]*auth.OIDCProvider
+	timeline      timeline.Timeline
+	scorer        ranking.Scorer
+	// tasks counts the work started with app.background, so shutdown can
+	// wait for it
+	tasks sync.WaitGroup
 }
 
 type config struct {
@@ -43,6 +52,27 @@ type config struct {
 	auth        authConfig
 	redisCfg    RedisConfig
 	ratelimiter ratelimiter.Config
+	sweeper     sweeperConfig
+	lockout     lockoutConfig
+	oidc        oidcConfig
+	timeline    timeline.Config
+	ranking     rankingConfig
+}
+
+type rankingConfig struct {
+	// window is how far back candidates for the ranked feed go
+	window     time.Duration
+	candidates int
+	// popularMin is the comments and reactions a post from someone the
+	// viewer doesn't follow needs to be a candidate
+	popularMin int
+	weights    ranking.Weights
+}
+
+type sweeperConfig struct {
+	enabled           bool
+	interval          time.Duration
+	unactivatedMaxAge time.Duration
 }
 
 type authConfig struct {
@@ -50,9 +80,15 @@ type authConfig struct {
 	token tokenConfig
 }
 type tokenConfig struct {
-	secret string
-	expiry time.Duration
-	issuer string
+	secret        string
+	expiry        time.Duration
+	refreshExpiry time.Duration
+	mfaExpiry     time.Duration
+	issuer        string
+	// keysDir switches signing from the shared secret to the RS256/EdDSA
+	// keys found there, signingKid picks the one that signs new tokens
+	keysDir    string
+	signingKid string
 }
 type basicConfig struct {
 	username string
@@ -66,9 +102,11 @@ type dbConfig struct {
 	maxIdleTime string
 }
 type mailConfig struct {
-	exp      time.Duration
-	fromUser string
-	sendGrid sendGridConfig
+	exp            time.Duration
+	resetExp       time.Duration
+	emailChangeExp time.Duration
+	fromUser       string
+	sendGrid       sendGridConfig
 }
 type sendGridConfig struct {
 	apiKey string
@@ -92,7 +130,7 @@ func (app *application) mount() http.Handler {
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
@@ -106,45 +144,151 @@ func (app *application) mount() http.Handler {
 	fmt.Printf("hti here")
 	r.Use(middleware.Timeout(60 * time.Second))
 	r.Get("/swagger/*", httpSwagger.WrapHandler)
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Get("/health", app.healthCheck)
 		r.Route("/posts", func(r chi.Router) {
 			r.Use(app.AuthTokenMiddleware)
-			r.Post("/create", app.createPost)
+			r.Post("/create", app.checkScope(scopePostsWrite, app.createPost))
+			r.Get("/search", app.checkScope(scopePostsRead, app.searchPostsHandler))
 			r.Route("/{postId}", func(r chi.Router) {
 				r.Use(app.postsContextMiddleware)
-				r.Get("/", app.getPostHandler)
-				r.Delete("/", app.checkPostOwnerShip("admin", app.deletePostById))
-				r.Patch("/", app.checkPostOwnerShip("moderator", app.updatePostById))
+				r.Get("/", app.checkScope(scopePostsRead, app.getPostHandler))
+				r.Get("/comments", app.checkScope(scopePostsRead, app.getPostCommentsHandler))
+				r.Post("/comments", app.checkScope(scopeCommentsWrite, app.createComment))
+				r.Delete("/", app.checkScope(scopePostsWrite, app.checkPostOwnerShip("admin", app.deletePostById)))
+				r.Patch("/", app.checkScope(scopePostsWrite, app.checkPostOwnerShip("moderator", app.updatePostById)))
+				r.Put("/reactions/{kind}", app.checkScope(scopePostsWrite, app.setReactionHandler))
+				r.Delete("/reactions/{kind}", app.checkScope(scopePostsWrite, app.deleteReactionHandler))
 			})
 		})
 		r.Route("/comments", func(r chi.Router) {
-			r.Post("/create/{postId}", app.createComment)
+			r.Route("/{commentId}", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/thread", app.checkScope(scopePostsRead, app.getCommentThreadHandler))
+				r.Group(func(r chi.Router) {
+					r.Use(app.commentsContextMiddleware)
+					r.Patch("/", app.checkScope(scopeCommentsWrite, app.checkCommentOwnerShip("moderator", app.updateCommentHandler)))
+					r.Delete("/", app.checkScope(scopeCommentsWrite, app.checkCommentOwnerShip("admin", app.deleteCommentHandler)))
+				})
+			})
 		})
 		r.Route("/users", func(r chi.Router) {
 			r.Put("/activate/{token}", app.activateUserHanlder)
+			r.Post("/activate/resend", app.resendActivationHandler)
+			r.Put("/email/confirm/{token}", app.confirmEmailChangeHandler)
 			r.Group(func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
-				r.Get("/feed", app.GetFeedForUser)
+				r.Get("/feed", app.checkScope(scopeFeedRead, app.GetFeedForUser))
+				r.Get("/search", app.checkScope(scopeUsersRead, app.searchUsersHandler))
+			})
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Patch("/", app.checkScope(scopeUsersWrite, app.updateProfileHandler))
+				r.Route("/mfa", func(r chi.Router) {
+					r.Use(app.SessionOnlyMiddleware)
+					r.Post("/enroll", app.mfaEnrollHandler)
+					r.Post("/enroll/confirm", app.mfaConfirmHandler)
+					r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+					r.Delete("/", app.mfaDisableHandler)
+				})
+				r.Route("/tokens", func(r chi.Router) {
+					r.Use(app.SessionOnlyMiddleware)
+					r.Get("/", app.listAPIKeysHandler)
+					r.Post("/", app.createAPIKeyHandler)
+					r.Delete("/{tokenId}", app.deleteAPIKeyHandler)
+				})
+				r.With(app.SessionOnlyMiddleware).Patch("/email", app.changeEmailHandler)
+				r.Get("/blocks", app.checkScope(scopeUsersRead, app.listBlockedHandler))
+				r.Get("/mutes", app.checkScope(scopeUsersRead, app.listMutedHandler))
+				r.Route("/follow-requests", func(r chi.Router) {
+					r.Get("/", app.checkScope(scopeUsersRead, app.listFollowRequestsHandler))
+					r.Post("/{userId}/approve", app.checkScope(scopeUsersWrite, app.approveFollowRequestHandler))
+					r.Post("/{userId}/reject", app.checkScope(scopeUsersWrite, app.rejectFollowRequestHandler))
+				})
+				r.Route("/identities", func(r chi.Router) {
+					r.Use(app.SessionOnlyMiddleware)
+					r.Get("/", app.listIdentitiesHandler)
+					r.Post("/{provider}", app.linkIdentityHandler)
+					r.Delete("/{provider}", app.unlinkIdentityHandler)
+				})
+				r.Route("/sessions", func(r chi.Router) {
+					r.Use(app.SessionOnlyMiddleware)
+					r.Get("/", app.listSessionsHandler)
+					r.Delete("/", app.revokeAllSessionsHandler)
+					r.Delete("/{sessionId}", app.revokeSessionHandler)
+				})
 			})
 			r.Route("/{userId}", func(r chi.Router) {
 				r.Use(app.AuthTokenMiddleware)
 
-				r.Get("/", app.getUserHandler)
-				r.Post("/follow", app.followUserHandler)
+				r.Get("/", app.checkScope(scopeUsersRead, app.getUserHandler))
+				r.Get("/followers", app.checkScope(scopeUsersRead, app.getFollowersHandler))
+				r.Get("/following", app.checkScope(scopeUsersRead, app.getFollowingHandler))
+				r.Post("/follow", app.checkScope(scopeUsersWrite, app.followUserHandler))
 				//TODO: will make it delete req when we add authintication via tokens
-				r.Put("/unfollow", app.unFollowUserHandler)
+				r.Put("/unfollow", app.checkScope(scopeUsersWrite, app.unFollowUserHandler))
+				r.Post("/block", app.checkScope(scopeUsersWrite, app.blockUserHandler))
+				r.Delete("/block", app.checkScope(scopeUsersWrite, app.unblockUserHandler))
+				r.Post("/mute", app.checkScope(scopeUsersWrite, app.muteUserHandler))
+				r.Delete("/mute", app.checkScope(scopeUsersWrite, app.unmuteUserHandler))
 			})
 		})
 		r.Route("/authinticate", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
 			r.Post("/token", app.createTokenHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.Post("/password/forgot", app.forgotPasswordHandler)
+			r.Post("/password/reset", app.resetPasswordHandler)
+			r.Get("/oidc/{provider}/login", app.oidcLoginHandler)
+			r.Post("/oidc/{provider}/callback", app.oidcCallbackHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.SessionOnlyMiddleware)
+				r.Post("/logout", app.logoutHandler)
+			})
+			r.Route("/mfa", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(app.MFATokenMiddleware(mfaStageVerify))
+					r.Post("/verify", app.mfaVerifyHandler)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(app.MFATokenMiddleware(mfaStageEnroll))
+					r.Post("/enroll", app.mfaEnrollHandler)
+					r.Post("/enroll/confirm", app.mfaConfirmHandler)
+				})
+			})
+		})
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.SessionOnlyMiddleware)
+			r.Put("/mfa-policy", app.checkRole("admin", app.updateMFAPolicyHandler))
+			r.Route("/users/{userId}", func(r chi.Router) {
+				r.Get("/", app.checkRole("admin", app.adminGetUserHandler))
+				r.Post("/unlock", app.checkRole("admin", app.unlockUserHandler))
+				r.Put("/role", app.checkRole("admin", app.updateUserRoleHandler))
+			})
 		})
 
 	})
 	return r
 }
 
+// background runs fn off the request path, for work whose outcome must not
+// change the response. fn logs its own errors, a panic is logged too.
+func (app *application) background(fn func()) {
+	app.tasks.Add(1)
+	go func() {
+		defer app.tasks.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background task panicked", "error", err)
+			}
+		}()
+		fn()
+	}()
+}
+
 func (app *application) run(mux http.Handler) error {
 	srv := http.Server{
 		Addr:         app.config.addr,
@@ -175,6 +319,7 @@ func (app *application) run(mux http.Handler) error {
 	if err != nil {
 		return err
 	}
+	app.tasks.Wait()
 
 	app.logger.Info("server has stopped", "addr", app.config.addr)
 	return nil
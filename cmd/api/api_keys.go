@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+// API keys are sent as "Authorization: ApiKey gsk_..." so they can never be
+// mistaken for a JWT.
+const (
+	apiKeyScheme = "ApiKey"
+	apiKeyPrefix = "gsk_"
+)
+
+const (
+	scopePostsRead     = "posts:read"
+	scopePostsWrite    = "posts:write"
+	scopeCommentsWrite = "comments:write"
+	scopeFeedRead      = "feed:read"
+	scopeUsersRead     = "users:read"
+	scopeUsersWrite    = "users:write"
+)
+
+type APIKeyType string
+
+var apiKeyCtx APIKeyType = "apiKey"
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=posts:read posts:write comments:write feed:read users:read users:write"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+}
+
+type APIKeyWithToken struct {
+	*store.APIKey
+	Token string `json:"token"`
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.badRequest(w, r, fmt.Errorf("expires_at must be in the future"))
+		return
+	}
+
+	plainToken, err := generateAPIKey()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	key := &store.APIKey{
+		UserId:    user.Id,
+		Name:      payload.Name,
+		Token:     hashToken(plainToken),
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+	}
+	if err := app.store.APIKey.Create(r.Context(), key); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the plain key is only ever shown in this response
+	if err := app.jsonResponse(w, http.StatusCreated, APIKeyWithToken{APIKey: key, Token: plainToken}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	keys, err := app.store.APIKey.GetByUserId(r.Context(), user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyId, err := strconv.ParseInt(chi.URLParam(r, "tokenId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	if err := app.store.APIKey.Delete(r.Context(), keyId, user.Id); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "token revoked"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// apiKeyAuth is the AuthTokenMiddleware path for requests carrying an API key.
+func (app *application) apiKeyAuth(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	plainToken := strings.TrimPrefix(r.Header.Get("Authorization"), apiKeyScheme+" ")
+	ctx := r.Context()
+
+	key, err := app.store.APIKey.GetByToken(ctx, hashToken(plainToken))
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.AuthorizationError(w, r, fmt.Errorf("api key is invalid or expired"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	user, err := app.getUser(ctx, key.UserId)
+	if err != nil {
+		app.AuthorizationError(w, r, err)
+		return
+	}
+	if err := app.store.APIKey.Touch(ctx, key.Id, clientIP(r)); err != nil {
+		app.logger.Warnw("Error recording api key usage", "key", key.Id, "error", err.Error())
+	}
+
+	ctx = context.WithValue(ctx, userCtx, user)
+	ctx = context.WithValue(ctx, apiKeyCtx, key)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// checkScope lets requests made with an API key through only if the key was
+// granted scope. Logged in users are not limited by scopes.
+func (app *application) checkScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := getAPIKeyFromContext(r); key != nil && !key.HasScope(scope) {
+			app.forbiddenError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SessionOnlyMiddleware keeps API keys away from account management routes.
+func (app *application) SessionOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getAPIKeyFromContext(r) != nil {
+			app.forbiddenError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getAPIKeyFromContext(r *http.Request) *store.APIKey {
+	key, _ := r.Context().Value(apiKeyCtx).(*store.APIKey)
+	return key
+}
+
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(buf), nil
+}
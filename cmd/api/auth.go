@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -43,8 +45,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	ctx := r.Context()
 
 	plainToken := uuid.New().String()
-	hash := sha256.Sum256([]byte(plainToken)) // not readble by human so cant store in sql
-	hashToken := hex.EncodeToString(hash[:])  // readable by human a string
+	hashToken := hashToken(plainToken)
 
 	userWithToken := UserWithToken{
 		User:  user,
@@ -56,21 +57,9 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	isProdEnv := app.config.env == "production"
-
-	activationURL := fmt.Sprint("%s/confirm/%s", app.config.frontEndURL, plainToken)
-	vars := struct {
-		ActivationURL string
-		Username      string
-	}{
-		ActivationURL: activationURL,
-		Username:      user.Username,
-	}
-
 	// sending mail
 
-	err := app.mailer.Send(mailer.UserRegisterMailTemplate, user.Username, user.Email, vars, !isProdEnv)
-	if err != nil {
+	if err := app.sendActivationMail(user, plainToken); err != nil {
 
 		if err := app.store.User.Delete(ctx, user.Id); err != nil {
 			app.logger.Errorw("Error Deleting", "user", err.Error())
@@ -85,6 +74,20 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) sendActivationMail(user *store.User, plainToken string) error {
+	isProdEnv := app.config.env == "production"
+
+	activationURL := fmt.Sprintf("%s/confirm/%s", app.config.frontEndURL, plainToken)
+	vars := struct {
+		ActivationURL string
+		Username      string
+	}{
+		ActivationURL: activationURL,
+		Username:      user.Username,
+	}
+	return app.mailer.Send(mailer.UserRegisterMailTemplate, user.Username, user.Email, vars, !isProdEnv)
+}
+
 type tokenPayloadType struct {
 	Email    string `json:"email" validate:"required"`
 	Password string `json:"password" validate:"required"`
@@ -101,10 +104,19 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	user, err := app.store.User.GetByEmail(r.Context(), tokenPayload.Email)
+	if !app.checkLoginThrottle(w, r, tokenPayload.Email) {
+		return
+	}
+	ctx := r.Context()
+
+	user, err := app.store.User.GetByEmail(ctx, tokenPayload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrorNotFound:
+			if _, err := app.recordLoginFailure(ctx, r, tokenPayload.Email, nil); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
 			app.AuthorizationError(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
@@ -112,26 +124,292 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if user.IsLocked() {
+		app.accountLockedError(w, r, time.Until(*user.LockedUntil).Round(time.Second).String())
+		return
+	}
+
 	if err := user.Password.Check(tokenPayload.Password); err != nil {
+		locked, err := app.recordLoginFailure(ctx, r, tokenPayload.Email, user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if locked {
+			app.accountLockedError(w, r, app.config.lockout.lockDuration.String())
+			return
+		}
 		app.forbiddenError(w, r)
 		return
 	}
+	if err := app.cacheStorage.LoginAttempts.Reset(ctx, accountAttemptKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled || user.Role.RequiresMFA {
+		app.mfaChallengeResponse(w, r, user)
+		return
+	}
 
+	tokens, err := app.issueTokens(r, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type refreshTokenPayloadType struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload refreshTokenPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	plainToken, next := app.newRefreshToken()
+	if err := app.store.RefreshToken.Rotate(r.Context(), hashToken(payload.RefreshToken), next); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound), errors.Is(err, store.ErrTokenReused):
+			app.AuthorizationError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	accessToken, err := app.generateAccessToken(next.UserId, next.FamilyId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens := authTokens{
+		AccessToken:  accessToken,
+		RefreshToken: plainToken,
+		ExpiresIn:    int64(app.config.auth.token.expiry.Seconds()),
+	}
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload refreshTokenPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	refreshToken, err := app.store.RefreshToken.GetByToken(ctx, hashToken(payload.RefreshToken))
+	if err != nil && !errors.Is(err, store.ErrorNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if refreshToken != nil && refreshToken.UserId == user.Id {
+		if err := app.store.RefreshToken.RevokeFamily(ctx, refreshToken.FamilyId); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	claims := getClaimsFromContext(r)
+	if jti, ok := claims["jti"].(string); ok {
+		exp, err := claims.GetExpirationTime()
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if err := app.cacheStorage.Token.Revoke(ctx, jti, time.Until(exp.Time)); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "logged out"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type forgotPasswordPayloadType struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+// forgotPasswordHandler answers the same way whether or not the email belongs
+// to an account so it can't be used to find registered users.
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	const message = "if the email is registered, a password reset link has been sent"
+
+	user, err := app.store.User.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.jsonResponse(w, http.StatusAccepted, message)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// creating the link and mailing it happen after the response, so it
+	// takes as long as for an unknown email
+	app.background(func() {
+		app.sendPasswordReset(user)
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) sendPasswordReset(user *store.User) {
+	plainToken := uuid.New().String()
+	if err := app.store.User.CreatePasswordReset(context.Background(), user.Id, hashToken(plainToken), app.config.mail.resetExp); err != nil {
+		app.logger.Errorw("Error creating password reset", "user", user.Id, "error", err.Error())
+		return
+	}
+
+	isProdEnv := app.config.env == "production"
+	vars := struct {
+		ResetURL  string
+		Username  string
+		ExpiresIn string
+	}{
+		ResetURL:  fmt.Sprintf("%s/password/reset/%s", app.config.frontEndURL, plainToken),
+		Username:  user.Username,
+		ExpiresIn: app.config.mail.resetExp.String(),
+	}
+	if err := app.mailer.Send(mailer.PasswordResetMailTemplate, user.Username, user.Email, vars, !isProdEnv); err != nil {
+		app.logger.Errorw("Error sending password reset mail", "user", user.Id, "error", err.Error())
+	}
+}
+
+type resetPasswordPayloadType struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,max=24"`
+}
+
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	user := &store.User{}
+	if err := user.Password.Set(payload.Password); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := app.store.User.ResetPassword(r.Context(), hashToken(payload.Token), user); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.badRequest(w, r, fmt.Errorf("reset token is invalid or expired"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "password has been reset"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type authTokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// issueTokens starts a new session for the user, the session id doubles as
+// the family of its refresh tokens and the sid claim of its access tokens.
+func (app *application) issueTokens(r *http.Request, userId int64) (*authTokens, error) {
+	ctx := r.Context()
+	session := &store.Session{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	if err := app.store.Session.Create(ctx, session); err != nil {
+		return nil, err
+	}
+
+	plainToken, refreshToken := app.newRefreshToken()
+	refreshToken.UserId = userId
+	refreshToken.FamilyId = session.Id
+	if err := app.store.RefreshToken.Create(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := app.generateAccessToken(userId, session.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &authTokens{
+		AccessToken:  accessToken,
+		RefreshToken: plainToken,
+		ExpiresIn:    int64(app.config.auth.token.expiry.Seconds()),
+	}, nil
+}
+
+func (app *application) generateAccessToken(userId int64, sessionId string) (string, error) {
 	claims := jwt.MapClaims{
-		"sub": user.Id,
+		"sub": userId,
+		"sid": sessionId,
+		"jti": uuid.New().String(),
 		"exp": time.Now().Add(app.config.auth.token.expiry).Unix(),
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
 		"iss": app.config.auth.token.issuer,
 		"aud": app.config.auth.token.issuer,
 	}
-	token, err := app.authenticator.GenarateToken(claims)
-	if err != nil {
-		app.internalServerError(w, r, err)
-		return
-	}
+	return app.authenticator.GenarateToken(claims)
+}
 
-	if err := app.jsonResponse(w, http.StatusOK, token); err != nil {
-		app.internalServerError(w, r, err)
+// newRefreshToken returns the plain token for the client and the hashed
+// record to persist.
+func (app *application) newRefreshToken() (string, *store.RefreshToken) {
+	plainToken := uuid.New().String()
+	return plainToken, &store.RefreshToken{
+		Token:  hashToken(plainToken),
+		Expiry: time.Now().Add(app.config.auth.token.refreshExpiry),
 	}
 }
+
+func hashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken)) // not readble by human so cant store in sql
+	return hex.EncodeToString(hash[:])        // readable by human a string
+}
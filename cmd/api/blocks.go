@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+type relationView struct {
+	*publicProfile
+	Since string `json:"since"`
+}
+
+type relationFunc func(ctx context.Context, userId int64, targetId int64) error
+
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	// blocking ends the follows both ways
+	block := func(ctx context.Context, userId int64, targetId int64) error {
+		if err := app.store.Block.Block(ctx, userId, targetId); err != nil {
+			return err
+		}
+		app.dropAuthorFromTimeline(ctx, userId, targetId)
+		app.dropAuthorFromTimeline(ctx, targetId, userId)
+		app.followersChanged(ctx, userId, -1)
+		app.followersChanged(ctx, targetId, -1)
+		return nil
+	}
+	app.changeRelation(w, r, block, http.StatusCreated, "blocked")
+}
+
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	unblock := func(ctx context.Context, userId int64, targetId int64) error {
+		if err := app.store.Block.Unblock(ctx, userId, targetId); err != nil {
+			return err
+		}
+		app.invalidateTimelines(ctx, userId, targetId)
+		return nil
+	}
+	app.changeRelation(w, r, unblock, http.StatusOK, "unblocked")
+}
+
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	mute := func(ctx context.Context, userId int64, targetId int64) error {
+		if err := app.store.Mute.Mute(ctx, userId, targetId); err != nil {
+			return err
+		}
+		app.dropAuthorFromTimeline(ctx, userId, targetId)
+		return nil
+	}
+	app.changeRelation(w, r, mute, http.StatusCreated, "muted")
+}
+
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	unmute := func(ctx context.Context, userId int64, targetId int64) error {
+		if err := app.store.Mute.Unmute(ctx, userId, targetId); err != nil {
+			return err
+		}
+		app.invalidateTimelines(ctx, userId)
+		return nil
+	}
+	app.changeRelation(w, r, unmute, http.StatusOK, "unmuted")
+}
+
+func (app *application) changeRelation(w http.ResponseWriter, r *http.Request, change relationFunc, status int, message string) {
+	targetId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	if targetId == user.Id {
+		app.badRequest(w, r, fmt.Errorf("you can't do that to yourself"))
+		return
+	}
+	ctx := r.Context()
+	if _, err := app.store.User.GetById(ctx, targetId); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := change(ctx, user.Id, targetId); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		case store.ErrConflict:
+			app.ConflictError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, status, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) listBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listRelations(w, r, app.store.Block.GetBlocked)
+}
+
+func (app *application) listMutedHandler(w http.ResponseWriter, r *http.Request) {
+	app.listRelations(w, r, app.store.Mute.GetMuted)
+}
+
+func (app *application) listRelations(w http.ResponseWriter, r *http.Request, list func(context.Context, int64, store.PaginatedFeedQuery) ([]store.Relation, error)) {
+	p, ok := app.readPagination(w, r, "desc")
+	if !ok {
+		return
+	}
+	relations, err := list(r.Context(), getUserFromContext(r).Id, p)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	views := make([]relationView, len(relations))
+	for i, rel := range relations {
+		views[i] = relationView{publicProfile: newPublicProfile(&rel.User), Since: rel.CreatedAt}
+	}
+	if err := app.jsonResponse(w, http.StatusOK, views); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -9,20 +11,12 @@ import (
 )
 
 type CommentPaylod struct {
-	UserId  int64  `json:"user_id" validate:"required"`
-	PostId  int64  `json:"post_id" validate:"required"`
-	Content string `json:"content" validate:"required"`
+	ParentId *int64 `json:"parent_id" validate:"omitempty,gte=1"`
+	Content  string `json:"content" validate:"required"`
 }
 
 func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
 	var commentPayload CommentPaylod
-	postId := chi.URLParam(r, "postId")
-	postIdint64, err := strconv.ParseInt(postId, 10, 64)
-	if err != nil {
-		app.badRequest(w, r, err)
-		return
-	}
-	commentPayload.PostId = postIdint64
 	if err := readJSON(w, r, &commentPayload); err != nil {
 		app.badRequest(w, r, err)
 		return
@@ -31,17 +25,157 @@ func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
 		app.badRequest(w, r, err)
 		return
 	}
+	user := getUserFromContext(r)
 	comment := &store.Comment{
-		PostId:  commentPayload.PostId,
-		UserId:  commentPayload.UserId,
-		Content: commentPayload.Content,
+		PostId:   getPostFromContext(r).Id,
+		ParentId: commentPayload.ParentId,
+		UserId:   user.Id,
+		Content:  commentPayload.Content,
+		User:     store.Author{Id: user.Id, Username: user.Username, DisplayName: user.DisplayName, AvatarURL: user.AvatarURL},
 	}
 	if err := app.store.Comment.Create(r.Context(), comment); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		case store.ErrBlocked:
+			app.forbiddenError(w, r)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) getPostCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.readPagination(w, r, "desc")
+	if !ok {
+		return
+	}
+	comments, err := app.store.Comment.GetCommentByPostId(r.Context(), getPostFromContext(r).Id, getUserFromContext(r).Id, p)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, comments); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+const (
+	defaultThreadDepth = 3
+	maxThreadDepth     = 10
+)
+
+func (app *application) getCommentThreadHandler(w http.ResponseWriter, r *http.Request) {
+	commentId, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+	if err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
-	if err := writeJSON(w, http.StatusCreated, comment); err != nil {
+	depth := defaultThreadDepth
+	if d := r.URL.Query().Get("depth"); d != "" {
+		depth, err = strconv.Atoi(d)
+		if err != nil || depth < 0 || depth > maxThreadDepth {
+			app.badRequest(w, r, fmt.Errorf("depth must be between 0 and %d", maxThreadDepth))
+			return
+		}
+	}
+	thread, err := app.store.Comment.GetThread(r.Context(), commentId, getUserFromContext(r).Id, depth)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, thread); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type CommentKey string
+
+var commentCtx CommentKey = "comment"
+
+func (app *application) commentsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		commentId, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+		if err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+		comment, err := app.store.Comment.GetById(ctx, commentId, getUserFromContext(r).Id)
+		if err != nil {
+			switch err {
+			case store.ErrorNotFound:
+				app.notFound(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommentFromContext(r *http.Request) *store.Comment {
+	comment, _ := r.Context().Value(commentCtx).(*store.Comment)
+	return comment
+}
+
+type UpdateCommentPayload struct {
+	Content string `json:"content" validate:"required"`
+	// Version is the version the client edited, it defaults to the current one
+	Version *int `json:"version" validate:"omitempty,gte=0"`
+}
+
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromContext(r)
+	var payload UpdateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	comment.Content = payload.Content
+	if payload.Version != nil {
+		comment.Version = *payload.Version
+	}
+	if err := app.store.Comment.Update(r.Context(), comment); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.ConflictError(w, r, fmt.Errorf("comment was changed by someone else, reload it and try again"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.store.Comment.Delete(r.Context(), getCommentFromContext(r).Id); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "Comment deleted successfully"); err != nil {
+		app.internalServerError(w, r, err)
+	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/samualhalder/go-social/internal/mailer"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+type changeEmailPayloadType struct {
+	Email    string `json:"email" validate:"required,email,max=100"`
+	Password string `json:"password" validate:"required"`
+}
+
+// changeEmailHandler only records the new address; users.email changes once
+// the link mailed to the new address is opened.
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload changeEmailPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+
+	user, err := app.store.User.GetById(ctx, getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := user.Password.Check(payload.Password); err != nil {
+		app.forbiddenError(w, r)
+		return
+	}
+	if strings.EqualFold(user.Email, payload.Email) {
+		app.badRequest(w, r, fmt.Errorf("email is the same as the current one"))
+		return
+	}
+
+	plainToken := uuid.New().String()
+	if err := app.store.User.CreateEmailChange(ctx, user.Id, payload.Email, hashToken(plainToken), app.config.mail.emailChangeExp); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.ConflictError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	isProdEnv := app.config.env == "production"
+	confirmVars := struct {
+		ConfirmURL string
+		Username   string
+		ExpiresIn  string
+	}{
+		ConfirmURL: fmt.Sprintf("%s/email/confirm/%s", app.config.frontEndURL, plainToken),
+		Username:   user.Username,
+		ExpiresIn:  app.config.mail.emailChangeExp.String(),
+	}
+	if err := app.mailer.Send(mailer.EmailChangeMailTemplate, user.Username, payload.Email, confirmVars, !isProdEnv); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	noticeVars := struct {
+		Username string
+		NewEmail string
+		ResetURL string
+	}{
+		Username: user.Username,
+		NewEmail: payload.Email,
+		ResetURL: fmt.Sprintf("%s/password/forgot", app.config.frontEndURL),
+	}
+	if err := app.mailer.Send(mailer.EmailChangeNoticeTemplate, user.Username, user.Email, noticeVars, !isProdEnv); err != nil {
+		app.logger.Errorw("Error sending email change notice", "user", user.Id, "error", err.Error())
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, "a confirmation link has been sent to the new email"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	ctx := r.Context()
+
+	user, err := app.store.User.ConfirmEmailChange(ctx, hashToken(token))
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.badRequest(w, r, err)
+		case store.ErrConflict:
+			app.ConflictError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.cacheStorage.User.Delete(ctx, user.Id); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "email updated"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
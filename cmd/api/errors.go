@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/samualhalder/go-social/internal/store"
 )
 
 func (app *application) badRequest(w http.ResponseWriter, r *http.Request, err error) {
@@ -41,3 +45,43 @@ func (app *application) rateLimitExcedError(w http.ResponseWriter, r *http.Reque
 	w.Header().Set("Retry-After", time)
 	writeJSONError(w, http.StatusTooManyRequests, "rate limit exced")
 }
+
+func (app *application) accountLockedError(w http.ResponseWriter, r *http.Request, time string) {
+	app.logger.Warnw("Account Locked Error", "Methode", r.Method, "Path", r.URL.Path, "retry after: "+time)
+	w.Header().Set("Retry-After", time)
+	writeJSONError(w, http.StatusLocked, "account is temporarily locked")
+}
+
+// invalidQueryError answers what is wrong with each query parameter, for the
+// field errors of parsing a query and the errors of validating it.
+func (app *application) invalidQueryError(w http.ResponseWriter, r *http.Request, err error) {
+	fields := store.FieldErrors{}
+	var validationErrs validator.ValidationErrors
+	switch {
+	case errors.As(err, &fields):
+	case errors.As(err, &validationErrs):
+		for _, fe := range validationErrs {
+			fields[fe.Field()] = validationMessage(fe)
+		}
+	default:
+		app.badRequest(w, r, err)
+		return
+	}
+	app.logger.Errorw("Bad Request", "Methode", r.Method, "Path", r.URL.Path, "error", fields.Error())
+	writeJSONFieldErrors(w, http.StatusBadRequest, "invalid query parameters", fields)
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "gte", "min":
+		return "must be at least " + fe.Param()
+	case "lte", "max":
+		return "must be at most " + fe.Param()
+	default:
+		return "is invalid"
+	}
+}
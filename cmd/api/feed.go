@@ -1,34 +1,156 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
 
+	"github.com/samualhalder/go-social/internal/ranking"
 	"github.com/samualhalder/go-social/internal/store"
 )
 
 func (app *application) GetFeedForUser(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	pagination := store.PaginatedFeedQuery{
-		Limit:  10,
-		Offset: 0,
-		Sort:   "desc",
+	feedQuery := store.FeedQuery{
+		PaginatedFeedQuery: store.PaginatedFeedQuery{
+			Limit:  10,
+			Offset: 0,
+			Sort:   "desc",
+		},
+		PostFilters: store.PostFilters{TagMode: "any"},
+		Mode:        "chronological",
 	}
-	p, err := pagination.Parse(r)
+	p, err := feedQuery.Parse(r)
 	if err != nil {
-		app.badRequest(w, r, err)
+		app.invalidQueryError(w, r, err)
 		return
 	}
-	if err := Validate.Struct(pagination); err != nil {
-		app.badRequest(w, r, err)
+	if err := Validate.Struct(p); err != nil {
+		app.invalidQueryError(w, r, err)
 		return
 	}
-	posts, err := app.store.Post.GetUserFeedPosts(ctx, 151, p)
+	userId := getUserFromContext(r).Id
+	var posts []store.PostWithMetaData
+	var nextCursor string
+	if p.Mode == "ranked" {
+		posts, nextCursor, err = app.rankedFeed(ctx, userId, p)
+	} else {
+		posts, nextCursor, err = app.chronologicalFeed(ctx, userId, p)
+	}
 	if err != nil {
-		app.internalServerError(w, r, err)
+		switch err {
+		case store.ErrInvalidCursor:
+			app.invalidQueryError(w, r, store.FieldErrors{"cursor": "is invalid"})
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
-	if err := app.jsonResponse(w, http.StatusOK, posts); err != nil {
+	if err := app.enrichFeed(ctx, posts, userId); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+	if p.UseOffset {
+		if err := app.jsonResponse(w, http.StatusOK, posts); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if nextCursor != "" {
+		setNextLink(w, r, nextCursor)
+	}
+	if err := app.jsonCursorResponse(w, http.StatusOK, posts, nextCursor); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// setNextLink points the Link header at the same request with the cursor of
+// the next page.
+func setNextLink(w http.ResponseWriter, r *http.Request, nextCursor string) {
+	next := *r.URL
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", `<`+next.RequestURI()+`>; rel="next"`)
+}
+
+// feedCommentsPerPost is how many of the latest comments each feed entry
+// shows.
+const feedCommentsPerPost = 2
+
+// enrichFeed adds the reactions and latest comments to the posts, with one
+// query for each whatever the number of posts.
+func (app *application) enrichFeed(ctx context.Context, posts []store.PostWithMetaData, viewerId int64) error {
+	postIds := make([]int64, len(posts))
+	for i, post := range posts {
+		postIds[i] = post.Id
+	}
+	reactions, err := app.store.Reaction.GetByPostIds(ctx, postIds, viewerId)
+	if err != nil {
+		return err
+	}
+	comments, err := app.store.Comment.GetLatestByPostIds(ctx, postIds, viewerId, feedCommentsPerPost)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Reactions = *reactions[posts[i].Id]
+		posts[i].LikedByMe = posts[i].MyReaction != nil
+		posts[i].Comments = comments[posts[i].Id]
+	}
+	return nil
+}
+
+func (app *application) chronologicalFeed(ctx context.Context, userId int64, q store.FeedQuery) ([]store.PostWithMetaData, string, error) {
+	// timelines only hold the newest posts first, and not enough of them to
+	// filter
+	if app.config.timeline.Enabled && !q.UseOffset && q.Sort == "desc" && !q.Filtered() {
+		posts, nextCursor, ok, err := app.timelineFeed(ctx, userId, q)
+		if err != nil || ok {
+			return posts, nextCursor, err
+		}
+	}
+	return app.store.Post.GetUserFeedPosts(ctx, userId, q)
+}
+
+type rankedCursor struct {
+	Offset int `json:"o"`
+}
+
+// rankedFeed scores the recent posts the user may want to see and returns
+// the requested slice of them. Scores change as posts age, so pages are
+// only as stable as the time between them.
+func (app *application) rankedFeed(ctx context.Context, userId int64, q store.FeedQuery) ([]store.PostWithMetaData, string, error) {
+	cfg := app.config.ranking
+	offset := q.Offset
+	if !q.UseOffset {
+		offset = 0
+		if q.Cursor != "" {
+			var cursor rankedCursor
+			if err := store.DecodeCursor(q.Cursor, &cursor); err != nil {
+				return nil, "", err
+			}
+			if cursor.Offset < 0 {
+				return nil, "", store.ErrInvalidCursor
+			}
+			offset = cursor.Offset
+		}
+	}
+
+	now := time.Now()
+	candidates, err := app.store.Post.GetFeedCandidates(ctx, userId, q, now.Add(-cfg.window), cfg.popularMin, cfg.candidates)
+	if err != nil {
+		return nil, "", err
+	}
+	ranking.Rank(candidates, app.scorer, now)
+
+	posts := []store.PostWithMetaData{}
+	for _, c := range candidates[min(offset, len(candidates)):min(offset+q.Limit, len(candidates))] {
+		posts = append(posts, c.PostWithMetaData)
+	}
+	if q.UseOffset || offset+q.Limit >= len(candidates) {
+		return posts, "", nil
+	}
+	nextCursor, err := store.EncodeCursor(rankedCursor{Offset: offset + q.Limit})
+	return posts, nextCursor, err
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+type followRequestView struct {
+	*publicProfile
+	RequestedAt string `json:"requested_at"`
+}
+
+func (app *application) listFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.readPagination(w, r, "asc")
+	if !ok {
+		return
+	}
+	requests, err := app.store.Follower.GetRequests(r.Context(), getUserFromContext(r).Id, p)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	views := make([]followRequestView, len(requests))
+	for i, req := range requests {
+		views[i] = followRequestView{publicProfile: newPublicProfile(&req.User), RequestedAt: req.CreatedAt}
+	}
+	if err := app.jsonResponse(w, http.StatusOK, views); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	approve := func(ctx context.Context, userId int64, targetId int64) error {
+		if err := app.store.Follower.ApproveRequest(ctx, userId, targetId); err != nil {
+			return err
+		}
+		app.invalidateTimelines(ctx, userId)
+		app.followersChanged(ctx, targetId, 1)
+		return nil
+	}
+	app.answerFollowRequest(w, r, approve, "follow request approved")
+}
+
+func (app *application) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.answerFollowRequest(w, r, app.store.Follower.RejectRequest, "follow request rejected")
+}
+
+func (app *application) answerFollowRequest(w http.ResponseWriter, r *http.Request, answer func(ctx context.Context, userId int64, targetId int64) error, message string) {
+	requesterId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := answer(r.Context(), requesterId, getUserFromContext(r).Id); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
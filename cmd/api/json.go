@@ -3,14 +3,46 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/samualhalder/go-social/internal/store"
 )
 
 var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	// report fields by the names clients use for them
+	Validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// readPagination reads the limit, offset and sort of a list, 20 items in
+// the given order by default. It writes the error response itself when the
+// query is invalid.
+func (app *application) readPagination(w http.ResponseWriter, r *http.Request, sort string) (store.PaginatedFeedQuery, bool) {
+	pagination := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   sort,
+	}
+	p, err := pagination.Parse(r)
+	if err != nil {
+		app.invalidQueryError(w, r, err)
+		return p, false
+	}
+	if err := Validate.Struct(p); err != nil {
+		app.invalidQueryError(w, r, err)
+		return p, false
+	}
+	return p, true
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) error {
@@ -34,6 +66,14 @@ func writeJSONError(w http.ResponseWriter, status int, message string) error {
 	return writeJSON(w, status, &envolope{Error: message})
 }
 
+func writeJSONFieldErrors(w http.ResponseWriter, status int, message string, fields map[string]string) error {
+	type envolope struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	return writeJSON(w, status, &envolope{Error: message, Fields: fields})
+}
+
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
 	type envelope struct {
 		Data  any  `json:"data"`
@@ -41,3 +81,18 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	}
 	return writeJSON(w, status, envelope{Data: data, Error: false})
 }
+
+// jsonCursorResponse is jsonResponse for cursor paginated lists. next_cursor
+// is null on the last page.
+func (app *application) jsonCursorResponse(w http.ResponseWriter, status int, data any, nextCursor string) error {
+	type envelope struct {
+		Data       any     `json:"data"`
+		NextCursor *string `json:"next_cursor"`
+		Error      bool    `json:"error"`
+	}
+	env := envelope{Data: data, Error: false}
+	if nextCursor != "" {
+		env.NextCursor = &nextCursor
+	}
+	return writeJSON(w, status, env)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/samualhalder/go-social/internal/auth"
+)
+
+// jwksHandler publishes the token verification keys. It is served raw, not in
+// the usual envelope, because JWKS clients expect the RFC 7517 document.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	keySet, ok := app.authenticator.(auth.KeySet)
+	if !ok {
+		app.notFound(w, r, fmt.Errorf("tokens are signed with a shared secret"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := writeJSON(w, http.StatusOK, keySet.JWKS()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samualhalder/go-social/internal/mailer"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+type lockoutConfig struct {
+	// failures are forgotten window after the first one
+	window time.Duration
+	// failures allowed before every further attempt has to wait, the wait
+	// starts at backoffBase and doubles with each failure
+	freeAttempts int
+	backoffBase  time.Duration
+	// per account failures that lock the account for lockDuration
+	maxFailures  int
+	lockDuration time.Duration
+	// per IP failures, across all accounts, that block the IP for lockDuration
+	ipMaxFailures int
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipAttemptKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// checkLoginThrottle writes a 429 and returns false while the IP or the
+// account is waiting out a backoff.
+func (app *application) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	ctx := r.Context()
+	for _, key := range []string{ipAttemptKey(r), accountAttemptKey(email)} {
+		wait, err := app.cacheStorage.LoginAttempts.BlockedFor(ctx, key)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return false
+		}
+		if wait > 0 {
+			app.rateLimitExcedError(w, r, wait.Round(time.Second).String())
+			return false
+		}
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed attempt against the IP and the account
+// and hands out backoff. It reports whether the account got locked by it,
+// user is nil when the email matched no account.
+func (app *application) recordLoginFailure(ctx context.Context, r *http.Request, email string, user *store.User) (bool, error) {
+	cfg := app.config.lockout
+
+	ipFailures, err := app.cacheStorage.LoginAttempts.Fail(ctx, ipAttemptKey(r), cfg.window)
+	if err != nil {
+		return false, err
+	}
+	if ipFailures >= cfg.ipMaxFailures {
+		if err := app.cacheStorage.LoginAttempts.Block(ctx, ipAttemptKey(r), cfg.lockDuration); err != nil {
+			return false, err
+		}
+	}
+
+	key := accountAttemptKey(email)
+	failures, err := app.cacheStorage.LoginAttempts.Fail(ctx, key, cfg.window)
+	if err != nil {
+		return false, err
+	}
+	if user != nil && failures >= cfg.maxFailures {
+		if err := app.lockAccount(ctx, user); err != nil {
+			return false, err
+		}
+		return true, app.cacheStorage.LoginAttempts.Reset(ctx, key)
+	}
+	if failures > cfg.freeAttempts {
+		if err := app.cacheStorage.LoginAttempts.Block(ctx, key, loginBackoff(cfg, failures)); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func loginBackoff(cfg lockoutConfig, failures int) time.Duration {
+	wait := cfg.backoffBase << (failures - cfg.freeAttempts - 1)
+	if wait <= 0 || wait > cfg.lockDuration {
+		return cfg.lockDuration
+	}
+	return wait
+}
+
+func (app *application) lockAccount(ctx context.Context, user *store.User) error {
+	until := time.Now().Add(app.config.lockout.lockDuration)
+	if err := app.store.User.Lock(ctx, user.Id, until); err != nil {
+		return err
+	}
+	user.LockedUntil = &until
+
+	isProdEnv := app.config.env == "production"
+	vars := struct {
+		Username    string
+		LockedUntil string
+		ResetURL    string
+	}{
+		Username:    user.Username,
+		LockedUntil: until.Format(time.RFC1123),
+		ResetURL:    fmt.Sprintf("%s/password/forgot", app.config.frontEndURL),
+	}
+	if err := app.mailer.Send(mailer.AccountLockedMailTemplate, user.Username, user.Email, vars, !isProdEnv); err != nil {
+		app.logger.Errorw("Error sending account locked mail", "user", user.Id, "error", err.Error())
+	}
+	return nil
+}
+
+type adminUserView struct {
+	*store.User
+	Locked         bool `json:"locked"`
+	FailedAttempts int  `json:"failed_attempts"`
+}
+
+func (app *application) adminGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	user, err := app.store.User.GetById(ctx, userId)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	failures, err := app.cacheStorage.LoginAttempts.Count(ctx, accountAttemptKey(user.Email))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	view := adminUserView{User: user, Locked: user.IsLocked(), FailedAttempts: failures}
+	if err := app.jsonResponse(w, http.StatusOK, view); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	user, err := app.store.User.GetById(ctx, userId)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.store.User.Unlock(ctx, user.Id); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.cacheStorage.LoginAttempts.Reset(ctx, accountAttemptKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "account unlocked"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
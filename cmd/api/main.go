@@ -15,7 +15,9 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -25,9 +27,11 @@ import (
 	"github.com/samualhalder/go-social/internal/db"
 	"github.com/samualhalder/go-social/internal/env"
 	"github.com/samualhalder/go-social/internal/mailer"
+	"github.com/samualhalder/go-social/internal/ranking"
 	"github.com/samualhalder/go-social/internal/ratelimiter"
 	"github.com/samualhalder/go-social/internal/store" // swagger docs
 	"github.com/samualhalder/go-social/internal/store/cache"
+	"github.com/samualhalder/go-social/internal/timeline"
 	"go.uber.org/zap"
 )
 
@@ -47,8 +51,10 @@ func main() {
 			maxIdleTime: env.GetString("MAX_IDLE_TIME", "15m"),
 		},
 		mail: mailConfig{
-			exp:      time.Hour * 24 * 3,
-			fromUser: env.GetString("FROM_USER", ""),
+			exp:            time.Hour * 24 * 3,
+			resetExp:       time.Hour,
+			emailChangeExp: time.Hour * 24,
+			fromUser:       env.GetString("FROM_USER", ""),
 			sendGrid: sendGridConfig{
 				apiKey: env.GetString("SENDGRID_API_KEY", ""),
 			},
@@ -59,9 +65,13 @@ func main() {
 				pass:     "sam",
 			},
 			token: tokenConfig{
-				secret: env.GetString("JWT_SECRET", "itsasecretok2323"),
-				expiry: time.Hour * 3 * 24,
-				issuer: env.GetString("TOKEN_ISSUER", "GO_SOCIAL"),
+				secret:        env.GetString("JWT_SECRET", "itsasecretok2323"),
+				expiry:        time.Minute * 15,
+				refreshExpiry: time.Hour * 24 * 30,
+				mfaExpiry:     time.Minute * 5,
+				issuer:        env.GetString("TOKEN_ISSUER", "GO_SOCIAL"),
+				keysDir:       env.GetString("JWT_KEYS_DIR", ""),
+				signingKid:    env.GetString("JWT_SIGNING_KID", ""),
 			},
 		},
 		redisCfg: RedisConfig{
@@ -75,6 +85,43 @@ func main() {
 			TimeFrame:           time.Second * 5,
 			Enabled:             env.GetBool("RATELIMITER_ENABLED", true),
 		},
+		sweeper: sweeperConfig{
+			enabled:           env.GetBool("SWEEPER_ENABLED", true),
+			interval:          env.GetDuration("SWEEPER_INTERVAL", time.Hour),
+			unactivatedMaxAge: env.GetDuration("UNACTIVATED_USER_MAX_AGE", time.Hour*24*7),
+		},
+		lockout: lockoutConfig{
+			window:        env.GetDuration("LOGIN_FAILURE_WINDOW", time.Minute*15),
+			freeAttempts:  env.GetInt("LOGIN_FREE_ATTEMPTS", 3),
+			backoffBase:   env.GetDuration("LOGIN_BACKOFF_BASE", time.Second),
+			maxFailures:   env.GetInt("LOGIN_MAX_FAILURES", 10),
+			lockDuration:  env.GetDuration("LOGIN_LOCK_DURATION", time.Minute*30),
+			ipMaxFailures: env.GetInt("LOGIN_IP_MAX_FAILURES", 50),
+		},
+		oidc: oidcConfig{
+			providers:   oidcProvidersFromEnv(),
+			stateExpiry: env.GetDuration("OIDC_STATE_EXPIRY", time.Minute*10),
+		},
+		timeline: timeline.Config{
+			Enabled:            env.GetBool("TIMELINE_ENABLED", true),
+			MaxLength:          env.GetInt("TIMELINE_MAX_LENGTH", 800),
+			CelebrityThreshold: int64(env.GetInt("TIMELINE_CELEBRITY_THRESHOLD", 10000)),
+			Expiry:             env.GetDuration("TIMELINE_EXPIRY", time.Hour*24*3),
+		},
+		ranking: rankingConfig{
+			window:     env.GetDuration("RANKING_WINDOW", time.Hour*24*7),
+			candidates: env.GetInt("RANKING_CANDIDATES", 500),
+			popularMin: env.GetInt("RANKING_POPULAR_MIN", 20),
+			weights: ranking.Weights{
+				HalfLife:   env.GetDuration("RANKING_HALF_LIFE", time.Hour*24),
+				Base:       env.GetFloat("RANKING_WEIGHT_BASE", 1),
+				Comments:   env.GetFloat("RANKING_WEIGHT_COMMENTS", 1.5),
+				Reactions:  env.GetFloat("RANKING_WEIGHT_REACTIONS", 1),
+				Affinity:   env.GetFloat("RANKING_WEIGHT_AFFINITY", 2),
+				TagOverlap: env.GetFloat("RANKING_WEIGHT_TAG_OVERLAP", 0.5),
+				Followed:   env.GetFloat("RANKING_WEIGHT_FOLLOWED", 2),
+			},
+		},
 	}
 
 	logger := zap.Must(zap.NewProduction()).Sugar()
@@ -96,6 +143,22 @@ func main() {
 	}
 	cacheStore := cache.NewRedisStore(rdb)
 
+	var authenticator auth.Authenticator = auth.NewJWTAuthenticator(cnf.auth.token.secret, cnf.auth.token.issuer, cnf.auth.token.issuer)
+	if cnf.auth.token.keysDir != "" {
+		authenticator, err = auth.NewKeySetAuthenticatorFromDir(cnf.auth.token.keysDir, cnf.auth.token.signingKid, cnf.auth.token.issuer, cnf.auth.token.issuer)
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Infow("🔑 token signing keys loaded", "kid", cnf.auth.token.signingKid)
+	}
+
+	oidcClient := &http.Client{Timeout: time.Second * 10}
+	oidcProviders := make(map[string]*auth.OIDCProvider)
+	for _, providerCnf := range cnf.oidc.providers {
+		oidcProviders[providerCnf.Name] = auth.NewOIDCProvider(providerCnf, oidcClient)
+		logger.Infow("🔐 identity provider configured", "provider", providerCnf.Name, "issuer", providerCnf.Issuer)
+	}
+
 	ratelimiter := ratelimiter.NewFixedWindowRateLimiter(cnf.ratelimiter.RequestPerTimeFrame, cnf.ratelimiter.TimeFrame)
 
 	app := application{
@@ -103,9 +166,18 @@ func main() {
 		store:  store, logger: logger,
 		cacheStorage:  cacheStore,
 		mailer:        mailer.NewSendGrid(cnf.mail.fromUser, cnf.mail.sendGrid.apiKey),
-		authenticator: auth.NewJWTAuthenticator(cnf.auth.token.secret, cnf.auth.token.issuer, cnf.auth.token.issuer),
+		authenticator: authenticator,
 		ratelimiter:   ratelimiter,
+		oidcProviders: oidcProviders,
+		timeline:      timeline.New(rdb, cnf.timeline),
+		scorer:        ranking.NewWeightedScorer(cnf.ranking.weights),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if cnf.sweeper.enabled {
+		go app.runSweeper(ctx)
 	}
+
 	mux := app.mount()
 	logger.Info("🛣️ Route setup is done")
 	logger.Fatal(app.run(mux))
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samualhalder/go-social/internal/auth"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+const (
+	mfaStageVerify = "verify"
+	mfaStageEnroll = "enroll"
+
+	recoveryCodeCount = 10
+)
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	Stage       string `json:"mfa_stage"`
+	Token       string `json:"mfa_token"`
+}
+
+// mfaChallengeResponse answers a correct password with a short lived pending
+// token instead of real tokens. Users that must use two factor but never set
+// it up get an enroll token so they can do it before logging in.
+func (app *application) mfaChallengeResponse(w http.ResponseWriter, r *http.Request, user *store.User) {
+	stage := mfaStageVerify
+	if !user.TOTPEnabled {
+		stage = mfaStageEnroll
+	}
+	claims := jwt.MapClaims{
+		"sub": user.Id,
+		"mfa": stage,
+		"exp": time.Now().Add(app.config.auth.token.mfaExpiry).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.auth.token.issuer,
+		"aud": app.config.auth.token.issuer,
+	}
+	token, err := app.authenticator.GenarateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	challenge := mfaChallenge{
+		MFARequired: true,
+		Stage:       stage,
+		Token:       token,
+	}
+	if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type mfaVerifyPayloadType struct {
+	Code         string `json:"code" validate:"omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+func (app *application) mfaVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload mfaVerifyPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	// codes are short, so guessing them is throttled like passwords are
+	if !app.checkLoginThrottle(w, r, user.Email) {
+		return
+	}
+	if user.IsLocked() {
+		app.accountLockedError(w, r, time.Until(*user.LockedUntil).Round(time.Second).String())
+		return
+	}
+
+	var verifyErr error
+	if payload.Code != "" {
+		valid, err := app.useTOTPCode(ctx, user, payload.Code)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !valid {
+			verifyErr = fmt.Errorf("invalid two factor code")
+		}
+	} else if err := app.store.User.UseRecoveryCode(ctx, user.Id, hashToken(payload.RecoveryCode)); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			verifyErr = fmt.Errorf("invalid recovery code")
+		default:
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+	if verifyErr != nil {
+		locked, err := app.recordLoginFailure(ctx, r, user.Email, user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if locked {
+			app.accountLockedError(w, r, app.config.lockout.lockDuration.String())
+			return
+		}
+		app.AuthorizationError(w, r, verifyErr)
+		return
+	}
+	if err := app.cacheStorage.LoginAttempts.Reset(ctx, accountAttemptKey(user.Email)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(r, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type mfaEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+func (app *application) mfaEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := app.store.User.GetById(ctx, getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		app.ConflictError(w, r, fmt.Errorf("two factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.store.User.SetTOTPSecret(ctx, user.Id, secret); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	enrollment := mfaEnrollment{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(app.config.auth.token.issuer, user.Email, secret),
+	}
+	if err := app.jsonResponse(w, http.StatusOK, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type mfaCodePayloadType struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type mfaConfirmation struct {
+	RecoveryCodes []string    `json:"recovery_codes"`
+	Tokens        *authTokens `json:"tokens,omitempty"`
+}
+
+// mfaConfirmHandler turns two factor on once the first code checks out. When
+// reached with an enroll token it also finishes the pending login.
+func (app *application) mfaConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	var payload mfaCodePayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	user, err := app.store.User.GetById(ctx, getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		app.ConflictError(w, r, fmt.Errorf("two factor authentication is already enabled"))
+		return
+	}
+	if user.TOTPSecret == "" {
+		app.badRequest(w, r, fmt.Errorf("two factor enrollment has not been started"))
+		return
+	}
+	valid, err := app.useTOTPCode(ctx, user, payload.Code)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if !valid {
+		app.badRequest(w, r, fmt.Errorf("invalid two factor code"))
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.store.User.EnableTOTP(ctx, user.Id, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	confirmation := mfaConfirmation{RecoveryCodes: codes}
+	if getClaimsFromContext(r)["mfa"] == mfaStageEnroll {
+		confirmation.Tokens, err = app.issueTokens(r, user.Id)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+	if err := app.jsonResponse(w, http.StatusOK, confirmation); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) mfaDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.checkTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	if user.Role.RequiresMFA {
+		app.forbiddenError(w, r)
+		return
+	}
+	if err := app.store.User.DisableTOTP(r.Context(), user.Id); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "two factor authentication disabled"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.checkTOTPCode(w, r)
+	if !ok {
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.store.User.ReplaceRecoveryCodes(r.Context(), user.Id, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, mfaConfirmation{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// checkTOTPCode guards changes to an enabled two factor setup with a current
+// code, it writes the error response itself when the check fails.
+func (app *application) checkTOTPCode(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	var payload mfaCodePayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return nil, false
+	}
+	user, err := app.store.User.GetById(r.Context(), getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+	if !user.TOTPEnabled {
+		app.badRequest(w, r, fmt.Errorf("two factor authentication is not enabled"))
+		return nil, false
+	}
+	valid, err := app.useTOTPCode(r.Context(), user, payload.Code)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+	if !valid {
+		app.badRequest(w, r, fmt.Errorf("invalid two factor code"))
+		return nil, false
+	}
+	return user, true
+}
+
+// useTOTPCode checks a two factor code of user and uses up its time step, so
+// the same code is refused when it is sent again.
+func (app *application) useTOTPCode(ctx context.Context, user *store.User, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	switch err := app.store.User.UseTOTPStep(ctx, user.Id, step); err {
+	case nil:
+		return true, nil
+	case store.ErrConflict:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+type mfaPolicyPayloadType struct {
+	MinLevel int `json:"min_level" validate:"gte=0"`
+}
+
+// updateMFAPolicyHandler makes two factor mandatory for roles at or above
+// min_level, zero lifts the requirement for everyone.
+func (app *application) updateMFAPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload mfaPolicyPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := app.store.Role.SetMFARequirement(r.Context(), payload.MinLevel); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, payload); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,6 +13,10 @@ import (
 	"github.com/samualhalder/go-social/internal/store"
 )
 
+type ClaimsType string
+
+var claimsCtx ClaimsType = "claims"
+
 func (app *application) BaiscAuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -48,48 +53,137 @@ func (app *application) BaiscAuthMiddleware() func(http.Handler) http.Handler {
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.AuthorizationError(w, r, fmt.Errorf("authorization token is missing"))
-			return
-		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.AuthorizationError(w, r, fmt.Errorf("authorization token form is not correct"))
+		if strings.HasPrefix(r.Header.Get("Authorization"), apiKeyScheme+" ") {
+			app.apiKeyAuth(w, r, next)
 			return
 		}
 
-		token := parts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
+		claims, userId, err := app.validateBearerToken(r)
 		if err != nil {
 			app.AuthorizationError(w, r, err)
 			return
 		}
-		claims := jwtToken.Claims.(jwt.MapClaims)
-		userId, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
-		if err != nil {
-			app.AuthorizationError(w, r, err)
+		// tokens handed out half way through a two factor login only work
+		// on the /authinticate/mfa routes
+		if _, ok := claims["mfa"]; ok {
+			app.AuthorizationError(w, r, fmt.Errorf("two factor authentication is not complete"))
 			return
 		}
 		ctx := r.Context()
+		if jti, ok := claims["jti"].(string); ok {
+			revoked, err := app.cacheStorage.Token.IsRevoked(ctx, jti)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			if revoked {
+				app.AuthorizationError(w, r, fmt.Errorf("token has been revoked"))
+				return
+			}
+		}
+		if sid, ok := claims["sid"].(string); ok {
+			if err := app.store.Session.Touch(ctx, sid, clientIP(r)); err != nil {
+				switch err {
+				case store.ErrorNotFound:
+					app.AuthorizationError(w, r, fmt.Errorf("session has been revoked"))
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+		}
 		user, err := app.getUser(ctx, userId)
 		if err != nil {
 			app.AuthorizationError(w, r, err)
 			return
 		}
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, claimsCtx, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// MFATokenMiddleware accepts only the pending tokens issued for the given two
+// factor stage of a login.
+func (app *application) MFATokenMiddleware(stage string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, userId, err := app.validateBearerToken(r)
+			if err != nil {
+				app.AuthorizationError(w, r, err)
+				return
+			}
+			if claims["mfa"] != stage {
+				app.AuthorizationError(w, r, fmt.Errorf("token is not valid for this step"))
+				return
+			}
+			ctx := r.Context()
+			user, err := app.store.User.GetById(ctx, userId)
+			if err != nil {
+				app.AuthorizationError(w, r, err)
+				return
+			}
+			ctx = context.WithValue(ctx, userCtx, user)
+			ctx = context.WithValue(ctx, claimsCtx, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (app *application) validateBearerToken(r *http.Request) (jwt.MapClaims, int64, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, 0, fmt.Errorf("authorization token is missing")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, 0, fmt.Errorf("authorization token form is not correct")
+	}
+
+	token := parts[1]
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return nil, 0, err
+	}
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	userId, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return claims, userId, nil
+}
+
+// clientIP is the address of the caller without the port, RealIP only
+// rewrites RemoteAddr when a proxy header is present.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func getClaimsFromContext(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsCtx).(jwt.MapClaims)
+	return claims
+}
+
 func (app *application) checkPostOwnerShip(roleName string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnerShip(roleName, func(r *http.Request) int64 { return getPostFromContext(r).UserId }, next)
+}
+
+func (app *application) checkCommentOwnerShip(roleName string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnerShip(roleName, func(r *http.Request) int64 { return getCommentFromContext(r).UserId }, next)
+}
+
+// checkOwnerShip lets the owner through, and anyone else whose role is at
+// least roleName.
+func (app *application) checkOwnerShip(roleName string, ownerId func(*http.Request) int64, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := getUserFromContext(r)
-		post := getPostFromContext(r)
 		// same user
-		if user.Id == post.UserId {
+		if user.Id == ownerId(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -108,6 +202,22 @@ func (app *application) checkPostOwnerShip(roleName string, next http.HandlerFun
 	})
 }
 
+func (app *application) checkRole(roleName string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+		flag, err := app.checkRolePrecedence(r.Context(), user, roleName)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !flag {
+			app.forbiddenError(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
 	role, err := app.store.Role.GetByName(ctx, roleName)
 
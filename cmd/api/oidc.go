@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/samualhalder/go-social/internal/auth"
+	"github.com/samualhalder/go-social/internal/env"
+	"github.com/samualhalder/go-social/internal/store"
+	"github.com/samualhalder/go-social/internal/store/cache"
+)
+
+// errNoIdentityEmail is returned for a new identity without an email, which
+// an account can't be created from.
+var errNoIdentityEmail = errors.New("the identity provider did not share an email address")
+
+type oidcConfig struct {
+	providers   []auth.OIDCConfig
+	stateExpiry time.Duration
+}
+
+// oidcProvidersFromEnv reads OIDC_PROVIDERS, a comma separated list of names,
+// and the OIDC_<NAME>_* settings of each of them.
+func oidcProvidersFromEnv() []auth.OIDCConfig {
+	var providers []auth.OIDCConfig
+	for _, name := range strings.Split(env.GetString("OIDC_PROVIDERS", ""), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, auth.OIDCConfig{
+			Name:         name,
+			Issuer:       env.GetString(prefix+"ISSUER", ""),
+			ClientID:     env.GetString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetString(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  env.GetString(prefix+"REDIRECT_URL", ""),
+			Scopes:       strings.Fields(env.GetString(prefix+"SCOPES", "openid email profile")),
+		})
+	}
+	return providers
+}
+
+type oidcAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	app.startOIDCFlow(w, r, 0)
+}
+
+// startOIDCFlow answers with the provider URL to send the user to. A non zero
+// linkUserId makes the callback link the identity instead of logging in.
+func (app *application) startOIDCFlow(w http.ResponseWriter, r *http.Request, linkUserId int64) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFound(w, r, fmt.Errorf("unknown identity provider"))
+		return
+	}
+	ctx := r.Context()
+
+	state, err := auth.RandomString()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	oauthState := &cache.OAuthState{Provider: provider.Name(), LinkUserId: linkUserId}
+	if oauthState.Verifier, err = auth.RandomString(); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if oauthState.Nonce, err = auth.RandomString(); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, oauthState.Nonce, oauthState.Verifier)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.cacheStorage.OAuthState.Set(ctx, state, oauthState, app.config.oidc.stateExpiry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, oidcAuthorization{AuthorizationURL: authURL}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type oidcCallbackPayloadType struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// oidcCallbackHandler finishes the flow the frontend started. The user is
+// found by a linked identity first, then by verified email, and created when
+// neither matches. Accounts created from an unverified email have to be
+// activated from the mail sent to it before they can log in.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	var payload oidcCallbackPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFound(w, r, fmt.Errorf("unknown identity provider"))
+		return
+	}
+	ctx := r.Context()
+
+	oauthState, err := app.cacheStorage.OAuthState.Take(ctx, payload.State)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if oauthState == nil || oauthState.Provider != provider.Name() {
+		app.badRequest(w, r, fmt.Errorf("login state is invalid or expired"))
+		return
+	}
+	identity, err := provider.Exchange(ctx, payload.Code, oauthState.Verifier, oauthState.Nonce)
+	if err != nil {
+		app.AuthorizationError(w, r, err)
+		return
+	}
+
+	if oauthState.LinkUserId != 0 {
+		app.linkIdentity(w, r, provider.Name(), identity, oauthState.LinkUserId)
+		return
+	}
+
+	user, err := app.userForIdentity(ctx, provider.Name(), identity)
+	if err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.ConflictError(w, r, fmt.Errorf("an account with this email already exists, log in and link %s from your account", provider.Name()))
+		case errNoIdentityEmail:
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !user.IsActive {
+		if err := app.jsonResponse(w, http.StatusAccepted, "an activation link has been sent to your email"); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if user.IsLocked() {
+		app.accountLockedError(w, r, time.Until(*user.LockedUntil).Round(time.Second).String())
+		return
+	}
+	if user.TOTPEnabled || user.Role.RequiresMFA {
+		app.mfaChallengeResponse(w, r, user)
+		return
+	}
+	tokens, err := app.issueTokens(r, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) userForIdentity(ctx context.Context, provider string, identity *auth.OIDCIdentity) (*store.User, error) {
+	linked, err := app.store.Identity.GetByProviderSubject(ctx, provider, identity.Subject)
+	switch err {
+	case nil:
+		return app.store.User.GetById(ctx, linked.UserId)
+	case store.ErrorNotFound:
+	default:
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, errNoIdentityEmail
+	}
+	newIdentity := &store.Identity{Provider: provider, Subject: identity.Subject, Email: identity.Email}
+
+	// an unverified email could belong to someone else, so it never links
+	// to an existing account
+	if identity.EmailVerified {
+		user, err := app.store.User.GetByEmail(ctx, identity.Email)
+		switch err {
+		case nil:
+			newIdentity.UserId = user.Id
+			if err := app.store.Identity.Create(ctx, newIdentity); err != nil {
+				return nil, err
+			}
+			return user, nil
+		case store.ErrorNotFound:
+		default:
+			return nil, err
+		}
+	}
+
+	user := &store.User{
+		Username: oidcUsername(identity),
+		Email:    identity.Email,
+		IsActive: identity.EmailVerified,
+	}
+	// nobody knows this password, it can be set later with a password reset
+	password, err := auth.RandomString()
+	if err != nil {
+		return nil, err
+	}
+	if err := user.Password.Set(password); err != nil {
+		return nil, err
+	}
+	plainToken := uuid.New().String()
+	if err := app.store.User.CreateWithIdentity(ctx, user, newIdentity, hashToken(plainToken), app.config.mail.exp); err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		if err := app.sendActivationMail(user, plainToken); err != nil {
+			if err := app.store.User.Delete(ctx, user.Id); err != nil {
+				app.logger.Errorw("Error Deleting", "user", err.Error())
+			}
+			return nil, err
+		}
+	}
+	return app.store.User.GetById(ctx, user.Id)
+}
+
+// oidcUsername picks a username from the identity, leaving room for the
+// suffix added when it is taken.
+func oidcUsername(identity *auth.OIDCIdentity) string {
+	username := identity.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(identity.Email, "@")
+	}
+	username = strings.Join(strings.Fields(username), "")
+	if len(username) > 20 {
+		username = username[:20]
+	}
+	return username
+}
+
+func (app *application) linkIdentity(w http.ResponseWriter, r *http.Request, provider string, identity *auth.OIDCIdentity, userId int64) {
+	linked := &store.Identity{
+		UserId:   userId,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := app.store.Identity.Create(r.Context(), linked); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.ConflictError(w, r, fmt.Errorf("this %s account is already linked", provider))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, linked); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	identities, err := app.store.Identity.GetByUserId(r.Context(), getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, identities); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	app.startOIDCFlow(w, r, getUserFromContext(r).Id)
+}
+
+func (app *application) unlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	if err := app.store.Identity.Delete(r.Context(), user.Id, chi.URLParam(r, "provider")); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "identity unlinked"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
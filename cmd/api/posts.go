@@ -55,6 +55,7 @@ func (app *application) createPost(w http.ResponseWriter, r *http.Request) {
 		app.internalServerError(w, r, err)
 		return
 	}
+	app.pushToTimelines(ctx, user, post)
 
 	if err := app.jsonResponse(w, http.StatusCreated, post); err != nil {
 		app.internalServerError(w, r, err)
@@ -62,14 +63,67 @@ func (app *application) createPost(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// searchPostsHandler takes the same limit, offset and sort parameters as the
+// feed, sort only applies when searching by filters alone.
+func (app *application) searchPostsHandler(w http.ResponseWriter, r *http.Request) {
+	search := store.PostSearchQuery{
+		PaginatedFeedQuery: store.PaginatedFeedQuery{
+			Limit:  10,
+			Offset: 0,
+			Sort:   "desc",
+		},
+		PostFilters: store.PostFilters{TagMode: "any"},
+	}
+	q, err := search.Parse(r)
+	if err != nil {
+		app.invalidQueryError(w, r, err)
+		return
+	}
+	if err := Validate.Struct(q); err != nil {
+		app.invalidQueryError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	viewerId := getUserFromContext(r).Id
+	posts, err := app.store.Post.Search(ctx, viewerId, q)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	postIds := make([]int64, len(posts))
+	for i, post := range posts {
+		postIds[i] = post.Id
+	}
+	reactions, err := app.store.Reaction.GetByPostIds(ctx, postIds, viewerId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	for i := range posts {
+		posts[i].Reactions = *reactions[posts[i].Id]
+	}
+	if err := app.jsonResponse(w, http.StatusOK, posts); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromContext(r)
 
-	comments, err := app.store.Comment.GetCommentByPostId(r.Context(), post.Id)
+	viewerId := getUserFromContext(r).Id
+	firstPage := store.PaginatedFeedQuery{Limit: 20, Offset: 0, Sort: "desc"}
+	comments, err := app.store.Comment.GetCommentByPostId(r.Context(), post.Id, viewerId, firstPage)
 	if err != nil {
 		app.internalServerError(w, r, err)
+		return
 	}
 	post.Comments = comments
+	reactions, err := app.store.Reaction.GetByPostIds(r.Context(), []int64{post.Id}, viewerId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	post.Reactions = *reactions[post.Id]
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -92,7 +146,9 @@ func (app *application) deletePostById(w http.ResponseWriter, r *http.Request) {
 		default:
 			app.internalServerError(w, r, err)
 		}
+		return
 	}
+	app.removeFromTimelines(ctx, getPostFromContext(r))
 	if err := app.jsonResponse(w, http.StatusOK, "Post deleted successfully"); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -142,7 +198,7 @@ func (app *application) postsContextMiddleware(next http.Handler) http.Handler {
 			app.badRequest(w, r, err)
 			return
 		}
-		post, err := app.store.Post.GetPostById(ctx, postIdint)
+		post, err := app.store.Post.GetPostById(ctx, postIdint, getUserFromContext(r).Id)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrorNotFound):
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+func (app *application) setReactionHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeReaction(w, r, app.store.Reaction.Set)
+}
+
+func (app *application) deleteReactionHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeReaction(w, r, app.store.Reaction.Delete)
+}
+
+// changeReaction answers with the post's reactions after the change, so
+// clients don't have to refetch the post.
+func (app *application) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, postId int64, userId int64, kind string) error) {
+	kind := chi.URLParam(r, "kind")
+	if !store.IsReactionKind(kind) {
+		app.badRequest(w, r, fmt.Errorf("reaction must be one of %s", strings.Join(store.ReactionKinds, ", ")))
+		return
+	}
+	post := getPostFromContext(r)
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	if err := change(ctx, post.Id, user.Id, kind); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	reactions, err := app.store.Reaction.GetByPostIds(ctx, []int64{post.Id}, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, reactions[post.Id]); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	sessions, err := app.store.Session.GetByUserId(r.Context(), user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	currentId, _ := getClaimsFromContext(r)["sid"].(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == currentId
+	}
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	sessionId, err := uuid.Parse(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := app.store.Session.Revoke(r.Context(), sessionId.String(), user.Id); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "session revoked"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// revokeAllSessionsHandler logs the user out everywhere, the current session
+// included.
+func (app *application) revokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	if err := app.store.Session.RevokeAll(r.Context(), user.Id); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, "all sessions revoked"); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type updateRolePayloadType struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// updateUserRoleHandler changes the role of a user and logs them out of every
+// session so no token keeps acting with the old role.
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload updateRolePayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+
+	role, err := app.store.Role.GetByName(ctx, payload.Role)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.store.User.UpdateRole(ctx, userId, role.Id); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if err := app.store.Session.RevokeAll(ctx, userId); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.cacheStorage.User.Delete(ctx, userId); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, role); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runSweeper periodically removes expired invitations and accounts that were
+// never activated until ctx is cancelled.
+func (app *application) runSweeper(ctx context.Context) {
+	ticker := time.NewTicker(app.config.sweeper.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.sweep(ctx)
+		}
+	}
+}
+
+func (app *application) sweep(ctx context.Context) {
+	users, err := app.store.User.DeleteUnactivated(ctx, app.config.sweeper.unactivatedMaxAge)
+	if err != nil {
+		app.logger.Errorw("Error sweeping unactivated users", "error", err.Error())
+		return
+	}
+	invitations, err := app.store.User.DeleteExpiredInvitations(ctx)
+	if err != nil {
+		app.logger.Errorw("Error sweeping expired invitations", "error", err.Error())
+		return
+	}
+	app.logger.Infow("sweeper finished", "users", users, "invitations", invitations)
+}
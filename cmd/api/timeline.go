@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+// Timelines are only a cache of the feed, so failing to update one is logged
+// and never fails the request. Posts the viewer may no longer see are
+// filtered out when the feed loads them, and timelines expire.
+
+// timelineReads caps how many times one feed page reads further into a
+// timeline whose entries were filtered out, before it comes from SQL.
+const timelineReads = 4
+
+// pushToTimelines adds a new post to the cached timeline of its author and,
+// unless the author is a celebrity, of their followers. The follower count
+// is read again, the cached user may be on the other side of the threshold
+// from what the feed query sees.
+func (app *application) pushToTimelines(ctx context.Context, author *store.User, post *store.Post) {
+	cfg := app.config.timeline
+	if !cfg.Enabled {
+		return
+	}
+	position, err := post.Position()
+	if err != nil {
+		app.logger.Warnw("timeline push failed", "post", post.Id, "error", err)
+		return
+	}
+	followerCount, err := app.store.Follower.GetFollowerCount(ctx, author.Id)
+	if err != nil {
+		app.logger.Warnw("timeline push failed", "post", post.Id, "error", err)
+		return
+	}
+	userIds := []int64{author.Id}
+	if followerCount <= cfg.CelebrityThreshold {
+		followerIds, err := app.store.Follower.GetFollowerIds(ctx, author.Id)
+		if err != nil {
+			app.logger.Warnw("timeline push failed", "post", post.Id, "error", err)
+			return
+		}
+		userIds = append(userIds, followerIds...)
+	}
+	if err := app.timeline.Push(ctx, position, userIds); err != nil {
+		app.logger.Warnw("timeline push failed", "post", post.Id, "error", err)
+	}
+}
+
+func (app *application) removeFromTimelines(ctx context.Context, post *store.Post) {
+	if !app.config.timeline.Enabled {
+		return
+	}
+	followerIds, err := app.store.Follower.GetFollowerIds(ctx, post.UserId)
+	if err == nil {
+		err = app.timeline.Remove(ctx, []int64{post.Id}, append(followerIds, post.UserId))
+	}
+	if err != nil {
+		app.logger.Warnw("timeline remove failed", "post", post.Id, "error", err)
+	}
+}
+
+// dropAuthorFromTimeline removes the posts of authorId from the timeline of
+// userId, after userId stopped following them.
+func (app *application) dropAuthorFromTimeline(ctx context.Context, userId int64, authorId int64) {
+	if !app.config.timeline.Enabled {
+		return
+	}
+	positions, err := app.store.Post.GetPositionsByUserId(ctx, authorId, app.config.timeline.MaxLength)
+	if err == nil {
+		postIds := make([]int64, len(positions))
+		for i, position := range positions {
+			postIds[i] = position.Id
+		}
+		err = app.timeline.Remove(ctx, postIds, []int64{userId})
+	}
+	if err != nil {
+		app.logger.Warnw("timeline remove failed", "user", userId, "author", authorId, "error", err)
+	}
+}
+
+// followersChanged is called after the follower count of authorId changed
+// by about delta. When that crossed the celebrity threshold, the posts the
+// followers' timelines hold no longer match what the feed query adds to
+// them, so the timelines are filled again. There can be many, that happens
+// after the response.
+func (app *application) followersChanged(ctx context.Context, authorId int64, delta int64) {
+	cfg := app.config.timeline
+	if !cfg.Enabled {
+		return
+	}
+	count, err := app.store.Follower.GetFollowerCount(ctx, authorId)
+	if err != nil {
+		app.logger.Warnw("timeline invalidate failed", "author", authorId, "error", err)
+		return
+	}
+	if (count-delta > cfg.CelebrityThreshold) == (count > cfg.CelebrityThreshold) {
+		return
+	}
+	app.background(func() {
+		ctx := context.Background()
+		followerIds, err := app.store.Follower.GetFollowerIds(ctx, authorId)
+		if err != nil {
+			app.logger.Warnw("timeline invalidate failed", "author", authorId, "error", err)
+			return
+		}
+		app.invalidateTimelines(ctx, followerIds...)
+	})
+}
+
+// invalidateTimelines is for users who started following, unmuted or
+// unblocked someone, their timelines are missing that account's older posts.
+func (app *application) invalidateTimelines(ctx context.Context, userIds ...int64) {
+	if !app.config.timeline.Enabled {
+		return
+	}
+	for _, userId := range userIds {
+		if err := app.timeline.Invalidate(ctx, userId); err != nil {
+			app.logger.Warnw("timeline invalidate failed", "user", userId, "error", err)
+		}
+	}
+}
+
+// timelineFeed serves a feed page from the cached timeline, filling it when
+// it is cold. Entries filtered out when the posts load are made up for by
+// reading further into the timeline. ok is false when the page has to come
+// from SQL instead.
+func (app *application) timelineFeed(ctx context.Context, userId int64, q store.FeedQuery) (posts []store.PostWithMetaData, nextCursor string, ok bool, err error) {
+	cfg := app.config.timeline
+	after, err := q.After()
+	if err != nil {
+		return nil, "", false, err
+	}
+	// one more than asked for tells whether there is a next page
+	limit := q.Limit + 1
+	for read := range timelineReads {
+		ids, ok, err := app.timeline.Page(ctx, userId, after, limit)
+		if err != nil {
+			app.logger.Warnw("timeline read failed", "user", userId, "error", err)
+			return nil, "", false, nil
+		}
+		if !ok && after == nil && read == 0 {
+			// only the first page fills a timeline, deeper pages of a cold or
+			// trimmed one come from SQL
+			if err := app.fillTimeline(ctx, userId); err != nil {
+				return nil, "", false, err
+			}
+			ids, ok, err = app.timeline.Page(ctx, userId, after, limit)
+			if err != nil {
+				app.logger.Warnw("timeline read failed", "user", userId, "error", err)
+				return nil, "", false, nil
+			}
+		}
+		if !ok {
+			return nil, "", false, nil
+		}
+		complete := len(ids) < limit
+		posts, nextCursor, err = app.store.Post.GetTimelinePosts(ctx, userId, ids, complete, cfg.CelebrityThreshold, q)
+		if err != nil || nextCursor != "" || complete {
+			return posts, nextCursor, true, err
+		}
+		limit *= 2
+	}
+	return nil, "", false, nil
+}
+
+func (app *application) fillTimeline(ctx context.Context, userId int64) error {
+	cfg := app.config.timeline
+	positions, err := app.store.Post.GetTimelineEntries(ctx, userId, cfg.CelebrityThreshold, cfg.MaxLength)
+	if err != nil {
+		return err
+	}
+	if err := app.timeline.Fill(ctx, userId, positions); err != nil {
+		app.logger.Warnw("timeline fill failed", "user", userId, "error", err)
+	}
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/samualhalder/go-social/internal/store"
 )
 
@@ -13,6 +15,27 @@ type UserType string
 
 var userCtx UserType = "user"
 
+// publicProfile is how a user is shown to everyone else, it never carries the
+// email or anything about how the account logs in.
+type publicProfile struct {
+	Id        int64  `json:"id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+	store.Profile
+	store.Counts
+	IsFollowedByMe bool `json:"is_followed_by_me"`
+}
+
+func newPublicProfile(user *store.User) *publicProfile {
+	return &publicProfile{
+		Id:        user.Id,
+		Username:  user.Username,
+		CreatedAt: user.CreatedAt,
+		Profile:   user.Profile,
+		Counts:    user.Counts,
+	}
+}
+
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
@@ -26,15 +49,205 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		case store.ErrorNotFound:
 			app.notFound(w, r, err)
 		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	// users looking at themselves get the full account
+	viewer := getUserFromContext(r)
+	if viewer.Id == user.Id {
+		if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	// a block hides the profile both ways, as if the user didn't exist
+	blocked, err := app.store.Block.IsBlocked(ctx, viewer.Id, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if blocked {
+		app.notFound(w, r, store.ErrorNotFound)
+		return
+	}
+	profile := newPublicProfile(user)
+	profile.IsFollowedByMe, err = app.store.Follower.IsFollowing(ctx, viewer.Id, user.Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, profile); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	search := store.UserSearchQuery{Limit: 20}
+	q, err := search.Parse(r)
+	if err != nil {
+		app.invalidQueryError(w, r, err)
+		return
+	}
+	if err := Validate.Struct(q); err != nil {
+		app.invalidQueryError(w, r, err)
+		return
+	}
+	results, nextCursor, err := app.store.User.Search(r.Context(), getUserFromContext(r).Id, q)
+	if err != nil {
+		switch err {
+		case store.ErrInvalidCursor:
 			app.badRequest(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	users := make([]*publicProfile, len(results))
+	for i, res := range results {
+		users[i] = newPublicProfile(&res.User)
+		users[i].IsFollowedByMe = res.IsFollowedByMe
+	}
+	if err := app.jsonCursorResponse(w, http.StatusOK, users, nextCursor); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type followListEntry struct {
+	*publicProfile
+	FollowedAt string `json:"followed_at"`
+}
+
+func (app *application) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Follower.GetFollowers)
+}
+
+func (app *application) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Follower.GetFollowing)
+}
+
+type followListFunc func(ctx context.Context, userId int64, viewerId int64, p store.PaginatedFeedQuery) ([]store.FollowEntry, error)
+
+func (app *application) listFollows(w http.ResponseWriter, r *http.Request, list followListFunc) {
+	ctx := r.Context()
+	userId, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	p, ok := app.readPagination(w, r, "desc")
+	if !ok {
+		return
+	}
+	if _, err := app.store.User.GetById(ctx, userId); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
 		}
+		return
+	}
+	// the lists of someone who blocked the viewer, or was blocked, are as
+	// hidden as their profile
+	viewer := getUserFromContext(r)
+	blocked, err := app.store.Block.IsBlocked(ctx, viewer.Id, userId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if blocked {
+		app.notFound(w, r, store.ErrorNotFound)
+		return
+	}
+
+	entries, err := list(ctx, userId, viewer.Id, p)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	users := make([]followListEntry, len(entries))
+	for i, entry := range entries {
+		users[i] = followListEntry{publicProfile: newPublicProfile(&entry.User), FollowedAt: entry.FollowedAt}
+		users[i].IsFollowedByMe = entry.IsFollowedByMe
+	}
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type updateProfilePayloadType struct {
+	DisplayName  *string  `json:"display_name" validate:"omitempty,max=50"`
+	Bio          *string  `json:"bio" validate:"omitempty,max=500"`
+	Location     *string  `json:"location" validate:"omitempty,max=100"`
+	WebsiteLinks []string `json:"website_links" validate:"omitempty,max=5,dive,http_url,max=200"`
+	AvatarURL    *string  `json:"avatar_url" validate:"omitempty,http_url,max=500"`
+	IsPrivate    *bool    `json:"is_private"`
+}
+
+// updateProfileHandler only changes the fields present in the body, an empty
+// string or list clears a field.
+func (app *application) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload updateProfilePayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
 	}
-	if err := writeJSON(w, http.StatusOK, user); err != nil {
+	if err := Validate.Struct(payload); err != nil {
 		app.badRequest(w, r, err)
 		return
 	}
+	ctx := r.Context()
+	user, err := app.store.User.GetById(ctx, getUserFromContext(r).Id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*payload.DisplayName)
+	}
+	if payload.Bio != nil {
+		user.Bio = strings.TrimSpace(*payload.Bio)
+	}
+	if payload.Location != nil {
+		user.Location = strings.TrimSpace(*payload.Location)
+	}
+	if payload.WebsiteLinks != nil {
+		user.WebsiteLinks = payload.WebsiteLinks
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+	wasPrivate := user.IsPrivate
+	if payload.IsPrivate != nil {
+		user.IsPrivate = *payload.IsPrivate
+	}
+
+	if err := app.store.User.UpdateProfile(ctx, user.Id, &user.Profile); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if wasPrivate && !user.IsPrivate {
+		approved, err := app.store.Follower.ApproveAllRequests(ctx, user.Id)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.invalidateTimelines(ctx, approved...)
+		app.followersChanged(ctx, user.Id, int64(len(approved)))
+	}
+	if err := app.cacheStorage.User.Delete(ctx, user.Id); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
 }
 
+// followUserHandler follows public accounts right away, private accounts get
+// a follow request they have to approve.
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	user := getUserFromContext(r)
@@ -45,10 +258,43 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 	}
 	ctx := r.Context()
 
+	followed, err := app.store.User.GetById(ctx, followedId)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFound(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if followed.IsPrivate {
+		if err := app.store.Follower.RequestFollow(ctx, user.Id, followedId); err != nil {
+			switch err {
+			case store.ErrConflict:
+				app.ConflictError(w, r, err)
+			case store.ErrBlocked:
+				app.forbiddenError(w, r)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		app.jsonResponse(w, http.StatusAccepted, "follow requested")
+		return
+	}
+
 	if err := app.store.Follower.Follow(ctx, followedId, user.Id); err != nil {
-		app.badRequest(w, r, err)
+		switch err {
+		case store.ErrBlocked:
+			app.forbiddenError(w, r)
+		default:
+			app.badRequest(w, r, err)
+		}
 		return
 	}
+	app.invalidateTimelines(ctx, user.Id)
+	app.followersChanged(ctx, followedId, 1)
 	app.jsonResponse(w, http.StatusOK, "followed")
 }
 
@@ -73,6 +319,8 @@ func (app *application) unFollowUserHandler(w http.ResponseWriter, r *http.Reque
 		app.badRequest(w, r, err)
 		return
 	}
+	app.dropAuthorFromTimeline(ctx, user.Id, followedId)
+	app.followersChanged(ctx, followedId, -1)
 	app.jsonResponse(w, http.StatusOK, "unfollowed")
 }
 
@@ -96,6 +344,49 @@ func (app *application) activateUserHanlder(w http.ResponseWriter, r *http.Reque
 		return
 	}
 }
+
+type resendActivationPayloadType struct {
+	Email string `json:"email" validate:"required,email,max=100"`
+}
+
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload resendActivationPayloadType
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	const message = "if the account is waiting for activation, a new activation link has been sent"
+
+	plainToken := uuid.New().String()
+	user, err := app.store.User.RegenerateInvitation(ctx, payload.Email, hashToken(plainToken), app.config.mail.exp)
+	if err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.jsonResponse(w, http.StatusAccepted, message)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// the mail goes out after the response, so a pending account answers as
+	// fast as an unknown email
+	app.background(func() {
+		if err := app.sendActivationMail(user, plainToken); err != nil {
+			app.logger.Errorw("Error sending activation mail", "user", user.Id, "error", err.Error())
+		}
+	})
+
+	if err := app.jsonResponse(w, http.StatusAccepted, message); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
 func (app *application) getUserContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS user_recovery_codes;
+
+ALTER TABLE IF EXISTS roles
+DROP COLUMN requires_mfa;
+
+ALTER TABLE IF EXISTS users
+DROP COLUMN totp_enabled,
+DROP COLUMN totp_secret;
@@ -0,0 +1,16 @@
+ALTER TABLE users
+ADD COLUMN totp_secret text,
+ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE roles
+ADD COLUMN requires_mfa BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes(
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    code text NOT NULL,
+    used_at timestamp(0) with time zone,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_recovery_codes_user_id ON user_recovery_codes (user_id);
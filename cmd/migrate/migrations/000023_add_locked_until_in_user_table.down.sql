@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS users
+DROP COLUMN
+locked_until;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+ADD COLUMN
+locked_until timestamp(0) with time zone;
@@ -0,0 +1,4 @@
+ALTER TABLE IF EXISTS refresh_tokens
+DROP CONSTRAINT IF EXISTS fk_refresh_tokens_session;
+
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS sessions(
+    id uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    ip varchar(64) NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    revoked_at timestamp(0) with time zone,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+-- a session is a refresh token family, so every existing family becomes one
+INSERT INTO sessions (id,user_id,created_at,last_seen_at,revoked_at)
+SELECT family_id,user_id,MIN(created_at),MAX(created_at),
+    CASE WHEN bool_and(revoked_at IS NOT NULL) THEN MAX(revoked_at) END
+FROM refresh_tokens
+GROUP BY family_id,user_id;
+
+ALTER TABLE refresh_tokens
+ADD CONSTRAINT fk_refresh_tokens_session
+FOREIGN KEY (family_id) REFERENCES sessions(id)
+ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+ALTER TABLE IF EXISTS users
+DROP COLUMN IF EXISTS display_name,
+DROP COLUMN IF EXISTS bio,
+DROP COLUMN IF EXISTS location,
+DROP COLUMN IF EXISTS website_links,
+DROP COLUMN IF EXISTS avatar_url;
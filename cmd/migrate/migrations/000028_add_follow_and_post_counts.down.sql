@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS trg_posts_count ON posts;
+DROP FUNCTION IF EXISTS update_post_count();
+DROP TRIGGER IF EXISTS trg_followers_counts ON followers;
+DROP FUNCTION IF EXISTS update_follow_counts();
+DROP INDEX IF EXISTS idx_followers_follower_id;
+
+ALTER TABLE IF EXISTS users
+DROP COLUMN IF EXISTS follower_count,
+DROP COLUMN IF EXISTS following_count,
+DROP COLUMN IF EXISTS post_count;
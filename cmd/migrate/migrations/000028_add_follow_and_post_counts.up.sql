@@ -0,0 +1,44 @@
+ALTER TABLE users
+ADD COLUMN follower_count bigint NOT NULL DEFAULT 0,
+ADD COLUMN following_count bigint NOT NULL DEFAULT 0,
+ADD COLUMN post_count bigint NOT NULL DEFAULT 0;
+
+-- a followers row (user_id, follower_id) means user_id follows follower_id
+UPDATE users u SET
+    follower_count = (SELECT COUNT(*) FROM followers f WHERE f.follower_id = u.id),
+    following_count = (SELECT COUNT(*) FROM followers f WHERE f.user_id = u.id),
+    post_count = (SELECT COUNT(*) FROM posts p WHERE p.user_id = u.id);
+
+CREATE INDEX idx_followers_follower_id ON followers (follower_id, created_at);
+
+CREATE OR REPLACE FUNCTION update_follow_counts() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE users SET following_count = following_count + 1 WHERE id = NEW.user_id;
+        UPDATE users SET follower_count = follower_count + 1 WHERE id = NEW.follower_id;
+        RETURN NEW;
+    END IF;
+    UPDATE users SET following_count = following_count - 1 WHERE id = OLD.user_id;
+    UPDATE users SET follower_count = follower_count - 1 WHERE id = OLD.follower_id;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_followers_counts
+AFTER INSERT OR DELETE ON followers
+FOR EACH ROW EXECUTE FUNCTION update_follow_counts();
+
+CREATE OR REPLACE FUNCTION update_post_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE users SET post_count = post_count + 1 WHERE id = NEW.user_id;
+        RETURN NEW;
+    END IF;
+    UPDATE users SET post_count = post_count - 1 WHERE id = OLD.user_id;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_posts_count
+AFTER INSERT OR DELETE ON posts
+FOR EACH ROW EXECUTE FUNCTION update_post_count();
@@ -0,0 +1,9 @@
+DROP TRIGGER IF EXISTS trg_comments_reply_count ON comments;
+DROP FUNCTION IF EXISTS update_reply_count();
+DROP INDEX IF EXISTS idx_comments_parent_id;
+DROP INDEX IF EXISTS idx_comments_post_id_parent_id;
+
+ALTER TABLE IF EXISTS comments
+DROP COLUMN IF EXISTS parent_id,
+DROP COLUMN IF EXISTS reply_count,
+DROP COLUMN IF EXISTS deleted_at;
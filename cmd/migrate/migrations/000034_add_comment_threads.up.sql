@@ -0,0 +1,22 @@
+ALTER TABLE comments
+ADD COLUMN parent_id bigint REFERENCES comments(id) ON DELETE CASCADE,
+ADD COLUMN reply_count bigint NOT NULL DEFAULT 0,
+ADD COLUMN deleted_at timestamp(0) with time zone;
+
+CREATE INDEX idx_comments_post_id_parent_id ON comments (post_id, parent_id, created_at);
+CREATE INDEX idx_comments_parent_id ON comments (parent_id, created_at);
+
+CREATE OR REPLACE FUNCTION update_reply_count() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE comments SET reply_count = reply_count + 1 WHERE id = NEW.parent_id;
+        RETURN NEW;
+    END IF;
+    UPDATE comments SET reply_count = reply_count - 1 WHERE id = OLD.parent_id;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_comments_reply_count
+AFTER INSERT OR DELETE ON comments
+FOR EACH ROW EXECUTE FUNCTION update_reply_count();
@@ -0,0 +1,3 @@
+ALTER TABLE comments
+ADD COLUMN edited_at timestamp(0) with time zone,
+ADD COLUMN version INT NOT NULL DEFAULT 0;
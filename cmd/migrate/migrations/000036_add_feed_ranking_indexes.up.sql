@@ -0,0 +1,3 @@
+CREATE INDEX idx_posts_created_at ON posts (created_at);
+CREATE INDEX idx_comments_user_id ON comments (user_id);
+CREATE INDEX idx_post_reactions_user_id ON post_reactions (user_id);
@@ -6,3 +6,9 @@ type Authenticator interface {
 	GenarateToken(claims jwt.Claims) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
 }
+
+// KeySet is implemented by authenticators whose verification keys can be
+// published so other services can check tokens on their own.
+type KeySet interface {
+	JWKS() JWKSet
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySetAuthenticator signs tokens with RS256 or EdDSA keys identified by kid.
+// Only the active key signs, every other loaded key still verifies, so a key
+// can be rotated out without invalidating the tokens it already signed.
+type KeySetAuthenticator struct {
+	keys   map[string]*signingKey
+	active *signingKey
+	aud    string
+	iss    string
+}
+
+// NewKeySetAuthenticatorFromDir loads every <kid>.pem file in dir. Files can
+// hold a private key (PKCS#1 or PKCS#8) or, for retired keys that only verify,
+// a public key. activeKid names the private key used for signing.
+func NewKeySetAuthenticatorFromDir(dir, activeKid, aud, iss string) (*KeySetAuthenticator, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	k := &KeySetAuthenticator{keys: make(map[string]*signingKey), aud: aud, iss: iss}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseSigningKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", file, err)
+		}
+		k.keys[kid] = key
+	}
+
+	active, ok := k.keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found in %s", activeKid, dir)
+	}
+	if active.private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", activeKid)
+	}
+	k.active = active
+	return k, nil
+}
+
+func parseSigningKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{kid: kid}
+	switch v := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, v, &v.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, v
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, v, v.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, v
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	return key, nil
+}
+
+func (k *KeySetAuthenticator) GenarateToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.kid
+	tokenString, err := token.SignedString(k.active.private)
+	if err != nil {
+		return "", err
+	}
+	return tokenString, nil
+}
+
+func (k *KeySetAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signin method %v", t.Header["alg"])
+		}
+		return key.public, nil
+	}, jwt.WithExpirationRequired(), jwt.WithAudience(k.aud), jwt.WithIssuer(k.iss), jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}))
+}
+
+func (k *KeySetAuthenticator) JWKS() JWKSet {
+	kids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		key := k.keys[kid]
+		set.Keys = append(set.Keys, NewJWK(kid, key.method.Alg(), key.public))
+	}
+	return set
+}
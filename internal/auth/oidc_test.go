@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "client"
+	testCode     = "code"
+	testVerifier = "verifier"
+	testNonce    = "nonce"
+	testKid      = "key-1"
+)
+
+// testIssuer is an OpenID Connect issuer that answers the token request with
+// whatever idToken returns.
+type testIssuer struct {
+	*httptest.Server
+	key     ed25519.PrivateKey
+	idToken func(issuer string) jwt.MapClaims
+	// kid is the key id put in the id token header
+	kid string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: private, kid: testKid}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{NewJWK(testKid, jwt.SigningMethodEdDSA.Alg(), public)}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != testCode ||
+			r.PostFormValue("code_verifier") != testVerifier || r.PostFormValue("client_id") != testClientID {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, issuer.idToken(issuer.URL))
+		token.Header["kid"] = issuer.kid
+		signed, err := token.SignedString(issuer.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (i *testIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Name:        "test",
+		Issuer:      i.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://example.com/callback",
+		Scopes:      []string{"openid", "email"},
+	}, i.Client())
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                issuer,
+		"aud":                testClientID,
+		"sub":                "subject",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              testNonce,
+		"email":              "user@example.com",
+		"email_verified":     "true",
+		"preferred_username": "user",
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+	authURL, err := issuer.provider().AuthCodeURL(context.Background(), "state", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") {
+		t.Fatalf("auth url %q does not use the discovered endpoint", authURL)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	challenge := sha256.Sum256([]byte(testVerifier))
+	want := map[string]string{
+		"client_id":             testClientID,
+		"state":                 "state",
+		"nonce":                 testNonce,
+		"scope":                 "openid email",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for param, value := range want {
+		if got := query.Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  func(claims jwt.MapClaims)
+		kid     string
+		code    string
+		nonce   string
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "wrong nonce", nonce: "other", wantErr: true},
+		{name: "missing nonce", claims: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: true},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "other" }, wantErr: true},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://other.example.com" }, wantErr: true},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, wantErr: true},
+		{name: "no subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+		{name: "unknown key", kid: "key-2", wantErr: true},
+		{name: "rejected code", code: "other", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.idToken = func(url string) jwt.MapClaims {
+				claims := validClaims(url)
+				if tt.claims != nil {
+					tt.claims(claims)
+				}
+				return claims
+			}
+			if tt.kid != "" {
+				issuer.kid = tt.kid
+			}
+			code, nonce := testCode, testNonce
+			if tt.code != "" {
+				code = tt.code
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := issuer.provider().Exchange(context.Background(), code, testVerifier, nonce)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got identity %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := OIDCIdentity{Subject: "subject", Email: "user@example.com", EmailVerified: true, PreferredUsername: "user"}
+			if *identity != want {
+				t.Fatalf("identity = %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := issuer.provider()
+	provider.config.Issuer = issuer.URL + "/"
+	if _, err := provider.AuthCodeURL(context.Background(), "state", testNonce, testVerifier); err == nil {
+		t.Fatal("expected an error for an issuer that differs from the discovery document")
+	}
+}
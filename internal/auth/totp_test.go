@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890"
+// in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPVectors(t *testing.T) {
+	// the RFC lists 8 digit codes, these are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+			if !ok {
+				t.Fatalf("code %s was refused at %d", tt.code, tt.unix)
+			}
+			if want := tt.unix / totpPeriod; step != want {
+				t.Fatalf("step = %d, want %d", step, want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	// 005924 is the code of step 41152263, which starts at 1234567890
+	const (
+		code = "005924"
+		step = 41152263
+	)
+	start := int64(step * totpPeriod)
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{name: "start of its step", unix: start, ok: true},
+		{name: "end of its step", unix: start + totpPeriod - 1, ok: true},
+		{name: "end of the next step", unix: start + 2*totpPeriod - 1, ok: true},
+		{name: "two steps later", unix: start + 2*totpPeriod, ok: false},
+		{name: "start of the previous step", unix: start - totpPeriod, ok: true},
+		{name: "two steps earlier", unix: start - totpPeriod - 1, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(tt.unix, 0))
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != step {
+				t.Fatalf("step = %d, want %d", got, step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInvalid(t *testing.T) {
+	now := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{name: "short code", secret: rfc6238Secret, code: "28708"},
+		{name: "long code", secret: rfc6238Secret, code: "2870820"},
+		{name: "eight digit code", secret: rfc6238Secret, code: "94287082"},
+		{name: "empty code", secret: rfc6238Secret, code: ""},
+		{name: "wrong code", secret: rfc6238Secret, code: "287083"},
+		// an empty key still gives codes, they must not be accepted
+		{name: "empty secret", secret: "", code: totpCode(nil, uint64(now.Unix()/totpPeriod))},
+		{name: "invalid base32 secret", secret: "not base32!", code: "287082"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, now); ok {
+				t.Fatalf("code %q was accepted", tt.code)
+			}
+		})
+	}
+}
@@ -3,9 +3,13 @@ package mailer
 import "embed"
 
 const (
-	FromName                 = "GO-SOCIAL"
-	MaxRetries               = 3
-	UserRegisterMailTemplate = "registermail.tmpl"
+	FromName                  = "GO-SOCIAL"
+	MaxRetries                = 3
+	UserRegisterMailTemplate  = "registermail.tmpl"
+	PasswordResetMailTemplate = "passwordresetmail.tmpl"
+	AccountLockedMailTemplate = "accountlockedmail.tmpl"
+	EmailChangeMailTemplate   = "emailchangemail.tmpl"
+	EmailChangeNoticeTemplate = "emailchangenoticemail.tmpl"
 )
 
 //go:embed "templates"
@@ -61,6 +61,5 @@ func (s *SendGridMailer) Send(templateFile, username, email string, data any, is
 		log.Print("result", res)
 		return nil
 	}
-	fmt.Errorf("failed to send email after %d attempts", MaxRetries)
-	return nil
+	return fmt.Errorf("failed to send email after %d attempts", MaxRetries)
 }
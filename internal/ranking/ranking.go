@@ -0,0 +1,74 @@
+package ranking
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+// Scorer rates how much a viewer should want to see a post, higher first.
+// Alternative rankers implement it to be tried side by side.
+type Scorer interface {
+	Score(c store.FeedCandidate, now time.Time) float64
+}
+
+// Weights tune the WeightedScorer. Base is what every post starts with, the
+// counts are damped with log(1+n) so a viral post doesn't drown everything
+// else.
+type Weights struct {
+	// HalfLife is the age at which a post's score has halved.
+	HalfLife   time.Duration
+	Base       float64
+	Comments   float64
+	Reactions  float64
+	Affinity   float64
+	TagOverlap float64
+	Followed   float64
+}
+
+// WeightedScorer adds up the weighted signals and decays the sum with the
+// age of the post.
+type WeightedScorer struct {
+	weights Weights
+}
+
+func NewWeightedScorer(weights Weights) *WeightedScorer {
+	return &WeightedScorer{weights: weights}
+}
+
+func (s *WeightedScorer) Score(c store.FeedCandidate, now time.Time) float64 {
+	w := s.weights
+	score := w.Base +
+		w.Comments*math.Log1p(float64(c.CommentCount)) +
+		w.Reactions*math.Log1p(float64(c.ReactionCount)) +
+		w.Affinity*math.Log1p(float64(c.Affinity)) +
+		w.TagOverlap*float64(c.TagOverlap)
+	if c.Followed {
+		score += w.Followed
+	}
+	if w.HalfLife > 0 {
+		age := max(now.Sub(c.PostedAt), 0)
+		score *= math.Exp2(-float64(age) / float64(w.HalfLife))
+	}
+	return score
+}
+
+// Rank sorts the candidates by score, newest first among equal scores.
+func Rank(candidates []store.FeedCandidate, scorer Scorer, now time.Time) {
+	scores := make(map[int64]float64, len(candidates))
+	for _, c := range candidates {
+		scores[c.Id] = scorer.Score(c, now)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if scores[a.Id] != scores[b.Id] {
+			return scores[a.Id] > scores[b.Id]
+		}
+		if !a.PostedAt.Equal(b.PostedAt) {
+			return a.PostedAt.After(b.PostedAt)
+		}
+		return a.Id > b.Id
+	})
+}
@@ -0,0 +1,109 @@
+package ranking
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+var now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// candidate is post id posted age ago.
+func candidate(id int64, age time.Duration) store.FeedCandidate {
+	c := store.FeedCandidate{PostedAt: now.Add(-age)}
+	c.Id = id
+	return c
+}
+
+func TestWeightedScorerDecay(t *testing.T) {
+	scorer := NewWeightedScorer(Weights{HalfLife: 6 * time.Hour, Base: 1})
+	tests := []struct {
+		name string
+		age  time.Duration
+		want float64
+	}{
+		{name: "new", age: 0, want: 1},
+		{name: "one half life", age: 6 * time.Hour, want: 0.5},
+		{name: "two half lives", age: 12 * time.Hour, want: 0.25},
+		{name: "from the future", age: -time.Hour, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scorer.Score(candidate(1, tt.age), now)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRank(t *testing.T) {
+	weights := Weights{HalfLife: 6 * time.Hour, Base: 1, Comments: 1, Reactions: 1, Affinity: 2, Followed: 2}
+	followed := candidate(1, 3*time.Hour)
+	followed.Followed = true
+	engaged := candidate(2, 3*time.Hour)
+	engaged.Affinity = 10
+	popular := candidate(3, 6*time.Hour)
+	popular.CommentCount = 50
+	popular.ReactionCount = 50
+
+	tests := []struct {
+		name       string
+		weights    Weights
+		candidates []store.FeedCandidate
+		want       []int64
+	}{
+		{
+			name:       "followed lifts an older post over a newer one",
+			weights:    weights,
+			candidates: []store.FeedCandidate{candidate(9, time.Hour), followed},
+			want:       []int64{1, 9},
+		},
+		{
+			name:       "affinity lifts an older post over a newer one",
+			weights:    weights,
+			candidates: []store.FeedCandidate{candidate(9, time.Hour), engaged},
+			want:       []int64{2, 9},
+		},
+		{
+			name:       "engagement lifts an older post over a newer one",
+			weights:    weights,
+			candidates: []store.FeedCandidate{candidate(9, time.Hour), popular},
+			want:       []int64{3, 9},
+		},
+		{
+			name:       "without signals newer posts win",
+			weights:    weights,
+			candidates: []store.FeedCandidate{candidate(1, 2*time.Hour), candidate(2, time.Hour)},
+			want:       []int64{2, 1},
+		},
+		{
+			name:       "ties are newest first",
+			weights:    Weights{Base: 1},
+			candidates: []store.FeedCandidate{candidate(1, 3*time.Hour), candidate(3, time.Hour), candidate(2, 2*time.Hour)},
+			want:       []int64{3, 2, 1},
+		},
+		{
+			name:       "ties in the same instant are by id",
+			weights:    Weights{Base: 1},
+			candidates: []store.FeedCandidate{candidate(1, time.Hour), candidate(2, time.Hour)},
+			want:       []int64{2, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := slices.Clone(tt.candidates)
+			Rank(candidates, NewWeightedScorer(tt.weights), now)
+			got := make([]int64, len(candidates))
+			for i, c := range candidates {
+				got[i] = c.Id
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
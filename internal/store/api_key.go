@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey is a long lived credential a user creates for scripts and bots. Only
+// the hash of the key is stored.
+type APIKey struct {
+	Id         int64      `json:"id"`
+	UserId     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	CreatedAt  string     `json:"created_at"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+func (a *APIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	query := `INSERT INTO api_keys (user_id,name,token,scopes,expires_at)
+	VALUES($1,$2,$3,$4,$5) RETURNING id,created_at`
+	err := a.db.QueryRowContext(
+		ctx,
+		query,
+		key.UserId,
+		key.Name,
+		key.Token,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+	).Scan(&key.Id, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *APIKeyStore) GetByToken(ctx context.Context, token string) (*APIKey, error) {
+	query := `SELECT id,user_id,name,scopes,expires_at,last_used_at,last_used_ip,created_at FROM api_keys
+			WHERE token=$1 AND (expires_at IS NULL OR expires_at>NOW())`
+	key := &APIKey{}
+	err := a.db.QueryRowContext(ctx, query, token).
+		Scan(&key.Id, &key.UserId, &key.Name, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func (a *APIKeyStore) GetByUserId(ctx context.Context, userId int64) ([]APIKey, error) {
+	query := `SELECT id,user_id,name,scopes,expires_at,last_used_at,last_used_ip,created_at FROM api_keys
+			WHERE user_id=$1 ORDER BY created_at DESC`
+	rows, err := a.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(&key.Id, &key.UserId, &key.Name, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.LastUsedIP, &key.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (a *APIKeyStore) Delete(ctx context.Context, keyId int64, userId int64) error {
+	query := `DELETE FROM api_keys WHERE id=$1 AND user_id=$2`
+	res, err := a.db.ExecContext(ctx, query, keyId, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (a *APIKeyStore) Touch(ctx context.Context, keyId int64, ip string) error {
+	query := `UPDATE api_keys SET last_used_at=NOW(),last_used_ip=$1 WHERE id=$2`
+	_, err := a.db.ExecContext(ctx, query, ip, keyId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
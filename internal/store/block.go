@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Relation is a user someone blocked or muted.
+type Relation struct {
+	UserId    int64  `json:"user_id"`
+	TargetId  int64  `json:"target_id"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"-"`
+}
+
+type BlockStore struct {
+	db *sql.DB
+}
+
+// Block also ends every follow and follow request between the two users, in
+// both directions.
+func (b *BlockStore) Block(ctx context.Context, userId int64, blockedId int64) error {
+	return WithTx(b.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO user_blocks (user_id,blocked_id) VALUES($1,$2)`
+		if _, err := tx.ExecContext(ctx, query, userId, blockedId); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+		query = `DELETE FROM followers WHERE (user_id=$1 AND follower_id=$2) OR (user_id=$2 AND follower_id=$1)`
+		if _, err := tx.ExecContext(ctx, query, userId, blockedId); err != nil {
+			return err
+		}
+		query = `DELETE FROM follow_requests WHERE (user_id=$1 AND target_id=$2) OR (user_id=$2 AND target_id=$1)`
+		_, err := tx.ExecContext(ctx, query, userId, blockedId)
+		return err
+	})
+}
+
+func (b *BlockStore) Unblock(ctx context.Context, userId int64, blockedId int64) error {
+	query := `DELETE FROM user_blocks WHERE user_id=$1 AND blocked_id=$2`
+	return deleteRelation(ctx, b.db, query, userId, blockedId)
+}
+
+func (b *BlockStore) GetBlocked(ctx context.Context, userId int64, p PaginatedFeedQuery) ([]Relation, error) {
+	query := `SELECT r.user_id,r.blocked_id,r.created_at,` + profileColumns + `
+			FROM user_blocks r JOIN users u ON u.id=r.blocked_id
+			WHERE r.user_id=$1
+			ORDER BY r.created_at ` + p.Sort + `
+			LIMIT $2 OFFSET $3`
+	return getRelations(ctx, b.db, query, userId, p)
+}
+
+// IsBlocked tells whether either user blocked the other.
+func (b *BlockStore) IsBlocked(ctx context.Context, userId int64, otherId int64) (bool, error) {
+	var blocked bool
+	query := `SELECT ` + blockedClause("$1", "$2")
+	if err := b.db.QueryRowContext(ctx, query, userId, otherId).Scan(&blocked); err != nil {
+		return false, err
+	}
+	return blocked, nil
+}
+
+type MuteStore struct {
+	db *sql.DB
+}
+
+// Mute hides mutedId's posts and comments from userId. Nothing changes for
+// the muted user, they are not told either.
+func (m *MuteStore) Mute(ctx context.Context, userId int64, mutedId int64) error {
+	query := `INSERT INTO user_mutes (user_id,muted_id) VALUES($1,$2)`
+	if _, err := m.db.ExecContext(ctx, query, userId, mutedId); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *MuteStore) Unmute(ctx context.Context, userId int64, mutedId int64) error {
+	query := `DELETE FROM user_mutes WHERE user_id=$1 AND muted_id=$2`
+	return deleteRelation(ctx, m.db, query, userId, mutedId)
+}
+
+func (m *MuteStore) GetMuted(ctx context.Context, userId int64, p PaginatedFeedQuery) ([]Relation, error) {
+	query := `SELECT r.user_id,r.muted_id,r.created_at,` + profileColumns + `
+			FROM user_mutes r JOIN users u ON u.id=r.muted_id
+			WHERE r.user_id=$1
+			ORDER BY r.created_at ` + p.Sort + `
+			LIMIT $2 OFFSET $3`
+	return getRelations(ctx, m.db, query, userId, p)
+}
+
+// blockedClause is true when either of the two users blocked the other.
+func blockedClause(a string, b string) string {
+	return `EXISTS (SELECT 1 FROM user_blocks ub WHERE (ub.user_id=` + a + ` AND ub.blocked_id=` + b + `)
+			OR (ub.user_id=` + b + ` AND ub.blocked_id=` + a + `))`
+}
+
+// hiddenAuthorClause is true when viewer doesn't want to or isn't allowed to
+// see content written by author, because of a block or a mute.
+func hiddenAuthorClause(author string, viewer string) string {
+	return `(` + blockedClause(author, viewer) + `
+			OR EXISTS (SELECT 1 FROM user_mutes um WHERE um.user_id=` + viewer + ` AND um.muted_id=` + author + `))`
+}
+
+func deleteRelation(ctx context.Context, db *sql.DB, query string, userId int64, targetId int64) error {
+	res, err := db.ExecContext(ctx, query, userId, targetId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func getRelations(ctx context.Context, db *sql.DB, query string, userId int64, p PaginatedFeedQuery) ([]Relation, error) {
+	rows, err := db.QueryContext(ctx, query, userId, p.Limit, p.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	relations := []Relation{}
+	for rows.Next() {
+		var rel Relation
+		err := rows.Scan(append([]any{&rel.UserId, &rel.TargetId, &rel.CreatedAt}, profileFields(&rel.User)...)...)
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, rel)
+	}
+	return relations, rows.Err()
+}
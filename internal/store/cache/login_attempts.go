@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LoginAttemptStore counts failed logins per key (an account or an IP) and
+// keeps the temporary blocks handed out as backoff.
+type LoginAttemptStore struct {
+	rdb *redis.Client
+}
+
+func (l *LoginAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	cacheKey := fmt.Sprintf("login-failures-%v", key)
+	count, err := l.rdb.Incr(ctx, cacheKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	// the window starts with the first failure
+	if count == 1 {
+		if err := l.rdb.Expire(ctx, cacheKey, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return int(count), nil
+}
+
+func (l *LoginAttemptStore) Count(ctx context.Context, key string) (int, error) {
+	cacheKey := fmt.Sprintf("login-failures-%v", key)
+	count, err := l.rdb.Get(ctx, cacheKey).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (l *LoginAttemptStore) Reset(ctx context.Context, key string) error {
+	return l.rdb.Del(ctx, fmt.Sprintf("login-failures-%v", key), fmt.Sprintf("login-block-%v", key)).Err()
+}
+
+func (l *LoginAttemptStore) Block(ctx context.Context, key string, d time.Duration) error {
+	cacheKey := fmt.Sprintf("login-block-%v", key)
+	return l.rdb.SetEX(ctx, cacheKey, "1", d).Err()
+}
+
+func (l *LoginAttemptStore) BlockedFor(ctx context.Context, key string) (time.Duration, error) {
+	cacheKey := fmt.Sprintf("login-block-%v", key)
+	ttl, err := l.rdb.PTTL(ctx, cacheKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	// missing keys come back as negative durations
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
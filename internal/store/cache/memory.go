@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryCounter struct {
+	count  int
+	expiry time.Time
+}
+
+// MemoryLoginAttemptStore keeps login attempts in process for when redis is
+// disabled. Counts are per instance, so limits are looser behind a load
+// balancer.
+type MemoryLoginAttemptStore struct {
+	sync.Mutex
+	failures map[string]memoryCounter
+	blocks   map[string]time.Time
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		failures: make(map[string]memoryCounter),
+		blocks:   make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryLoginAttemptStore) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	counter, ok := m.failures[key]
+	if !ok || now.After(counter.expiry) {
+		counter = memoryCounter{expiry: now.Add(window)}
+	}
+	counter.count++
+	m.failures[key] = counter
+	return counter.count, nil
+}
+
+func (m *MemoryLoginAttemptStore) Count(ctx context.Context, key string) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	counter, ok := m.failures[key]
+	if !ok || time.Now().After(counter.expiry) {
+		delete(m.failures, key)
+		return 0, nil
+	}
+	return counter.count, nil
+}
+
+func (m *MemoryLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.failures, key)
+	delete(m.blocks, key)
+	return nil
+}
+
+func (m *MemoryLoginAttemptStore) Block(ctx context.Context, key string, d time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+	m.blocks[key] = time.Now().Add(d)
+	return nil
+}
+
+func (m *MemoryLoginAttemptStore) BlockedFor(ctx context.Context, key string) (time.Duration, error) {
+	m.Lock()
+	defer m.Unlock()
+	until, ok := m.blocks[key]
+	if !ok {
+		return 0, nil
+	}
+	left := time.Until(until)
+	if left <= 0 {
+		delete(m.blocks, key)
+		return 0, nil
+	}
+	return left, nil
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// OAuthState is what we remember between sending a user to an identity
+// provider and the provider sending them back.
+type OAuthState struct {
+	Provider   string `json:"provider"`
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserId int64  `json:"link_user_id,omitempty"`
+}
+
+type OAuthStateStore struct {
+	rdb *redis.Client
+}
+
+func (o *OAuthStateStore) Set(ctx context.Context, state string, data *OAuthState, ttl time.Duration) error {
+	cacheKey := fmt.Sprintf("oauth-state-%v", state)
+	json, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return o.rdb.SetEX(ctx, cacheKey, string(json), ttl).Err()
+}
+
+// Take returns the state and forgets it, so a callback can't be replayed.
+func (o *OAuthStateStore) Take(ctx context.Context, state string) (*OAuthState, error) {
+	cacheKey := fmt.Sprintf("oauth-state-%v", state)
+	data, err := o.rdb.GetDel(ctx, cacheKey).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var oauthState OAuthState
+	if err := json.Unmarshal([]byte(data), &oauthState); err != nil {
+		return nil, err
+	}
+	return &oauthState, nil
+}
+
+type memoryOAuthState struct {
+	state  *OAuthState
+	expiry time.Time
+}
+
+// MemoryOAuthStateStore is the in process fallback for when redis is
+// disabled. The callback has to reach the same instance that started the
+// login.
+type MemoryOAuthStateStore struct {
+	sync.Mutex
+	states map[string]memoryOAuthState
+}
+
+func NewMemoryOAuthStateStore() *MemoryOAuthStateStore {
+	return &MemoryOAuthStateStore{states: make(map[string]memoryOAuthState)}
+}
+
+func (m *MemoryOAuthStateStore) Set(ctx context.Context, state string, data *OAuthState, ttl time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+	now := time.Now()
+	// drop abandoned logins so the map doesn't grow forever
+	for key, s := range m.states {
+		if now.After(s.expiry) {
+			delete(m.states, key)
+		}
+	}
+	m.states[state] = memoryOAuthState{state: data, expiry: now.Add(ttl)}
+	return nil
+}
+
+func (m *MemoryOAuthStateStore) Take(ctx context.Context, state string) (*OAuthState, error) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.states[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.states, state)
+	if time.Now().After(s.expiry) {
+		return nil, nil
+	}
+	return s.state, nil
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/samualhalder/go-social/internal/store"
@@ -11,11 +12,37 @@ type Store struct {
 	User interface {
 		Get(context.Context, int64) (*store.User, error)
 		Set(context.Context, *store.User) error
+		Delete(context.Context, int64) error
+	}
+	Token interface {
+		Revoke(context.Context, string, time.Duration) error
+		IsRevoked(context.Context, string) (bool, error)
+	}
+	LoginAttempts interface {
+		Fail(context.Context, string, time.Duration) (int, error)
+		Count(context.Context, string) (int, error)
+		Reset(context.Context, string) error
+		Block(context.Context, string, time.Duration) error
+		BlockedFor(context.Context, string) (time.Duration, error)
+	}
+	OAuthState interface {
+		Set(context.Context, string, *OAuthState, time.Duration) error
+		Take(context.Context, string) (*OAuthState, error)
 	}
 }
 
 func NewRedisStore(rdb *redis.Client) Store {
-	return Store{
-		User: &UserStore{rdb: rdb},
+	cacheStore := Store{
+		User:          &UserStore{rdb: rdb},
+		Token:         &TokenStore{rdb: rdb},
+		LoginAttempts: &LoginAttemptStore{rdb: rdb},
+		OAuthState:    &OAuthStateStore{rdb: rdb},
+	}
+	// login throttling and social login have to keep working with redis
+	// turned off
+	if rdb == nil {
+		cacheStore.LoginAttempts = NewMemoryLoginAttemptStore()
+		cacheStore.OAuthState = NewMemoryOAuthStateStore()
 	}
+	return cacheStore
 }
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type TokenStore struct {
+	rdb *redis.Client
+}
+
+// Revoke denylists an access token by its jti until ttl passes, after which
+// the token would have expired anyway.
+func (t *TokenStore) Revoke(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	cacheKey := fmt.Sprintf("revoked-token-%v", jti)
+	return t.rdb.SetEX(ctx, cacheKey, "1", ttl).Err()
+}
+
+func (t *TokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	cacheKey := fmt.Sprintf("revoked-token-%v", jti)
+	n, err := t.rdb.Exists(ctx, cacheKey).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -23,12 +23,12 @@ func (u *UserStore) Get(ctx context.Context, userId int64) (*store.User, error)
 	} else if err != nil {
 		return nil, err
 	}
-	var user *store.User
-	if data != "" {
-		err := json.Unmarshal([]byte(data), user)
-		if err != nil {
-			return nil, err
-		}
+	if data == "" {
+		return nil, nil
+	}
+	user := &store.User{}
+	if err := json.Unmarshal([]byte(data), user); err != nil {
+		return nil, err
 	}
 	return user, nil
 }
@@ -41,3 +41,8 @@ func (u *UserStore) Set(ctx context.Context, user *store.User) error {
 	}
 	return u.rdb.SetEX(ctx, cacheKey, string(json), time.Minute).Err()
 }
+
+func (u *UserStore) Delete(ctx context.Context, userId int64) error {
+	cacheKey := fmt.Sprintf("user-%v", userId)
+	return u.rdb.Del(ctx, cacheKey).Err()
+}
@@ -3,51 +3,275 @@ package store
 import (
 	"context"
 	"database/sql"
+
+	"github.com/lib/pq"
 )
 
 type CommentStore struct {
 	db *sql.DB
 }
 type Comment struct {
-	Id        int64  `json:"id"`
-	PostId    int64  `json:"post_id"`
-	UserId    int64  `json:"user_id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	User      User   `json:"user"`
+	Id         int64      `json:"id"`
+	PostId     int64      `json:"post_id"`
+	ParentId   *int64     `json:"parent_id"`
+	UserId     int64      `json:"user_id"`
+	Content    string     `json:"content"`
+	ReplyCount int64      `json:"reply_count"`
+	Deleted    bool       `json:"deleted"`
+	CreatedAt  string     `json:"created_at"`
+	EditedAt   *string    `json:"edited_at"`
+	Version    int        `json:"version"`
+	User       Author     `json:"user"`
+	Replies    []*Comment `json:"replies,omitempty"`
 }
 
-func (c *CommentStore) GetCommentByPostId(ctx context.Context, postId int64) ([]Comment, error) {
-	query := `SELECT a.id,a.post_id,a.content,a.created_at,b.id,b.username
+const commentColumns = `a.id,a.post_id,a.parent_id,a.content,a.reply_count,a.deleted_at IS NOT NULL,a.created_at,a.edited_at,a.version,b.id,b.username,b.display_name,b.avatar_url`
+
+func scanComment(row interface{ Scan(...any) error }, comment *Comment) error {
+	var user Author
+	err := row.Scan(&comment.Id, &comment.PostId, &comment.ParentId, &comment.Content, &comment.ReplyCount, &comment.Deleted, &comment.CreatedAt, &comment.EditedAt, &comment.Version,
+		&user.Id, &user.Username, &user.DisplayName, &user.AvatarURL)
+	if err != nil {
+		return err
+	}
+	// a tombstone only keeps its place in the thread
+	if !comment.Deleted {
+		comment.UserId = user.Id
+		comment.User = user
+	}
+	return nil
+}
+
+// GetCommentByPostId returns a page of the top level comments of a post,
+// leaving out comments of users viewerId blocked, muted or was blocked by.
+// Replies are counted in reply_count and fetched with GetThread.
+func (c *CommentStore) GetCommentByPostId(ctx context.Context, postId int64, viewerId int64, p PaginatedFeedQuery) ([]Comment, error) {
+	query := `SELECT ` + commentColumns + `
 			FROM comments a
 			JOIN users b
 			ON a.user_id=b.id
-			WHERE a.post_id=$1
-			ORDER BY a.created_at DESC;`
+			WHERE a.post_id=$1 AND a.parent_id IS NULL
+			AND (a.deleted_at IS NULL OR a.reply_count>0)
+			AND NOT ` + hiddenAuthorClause("a.user_id", "$2") + `
+			ORDER BY a.created_at ` + p.Sort + `,a.id ` + p.Sort + `
+			LIMIT $3 OFFSET $4`
 	comments := []Comment{}
-	rows, err := c.db.QueryContext(ctx, query, postId)
+	rows, err := c.db.QueryContext(ctx, query, postId, viewerId, p.Limit, p.Offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var user User
 		var comment Comment
-		err := rows.Scan(&comment.Id, &comment.PostId, &comment.Content, &comment.CreatedAt, &user.Id, &user.Username)
-		if err != nil {
+		if err := scanComment(rows, &comment); err != nil {
 			return nil, err
 		}
-		comment.User = user
 		comments = append(comments, comment)
 	}
-	return comments, nil
+	return comments, rows.Err()
+}
+
+// GetLatestByPostIds returns the newest perPost top level comments of each
+// post, newest first, leaving out comments of users viewerId blocked, muted
+// or was blocked by. Every post asked for gets an entry.
+func (c *CommentStore) GetLatestByPostIds(ctx context.Context, postIds []int64, viewerId int64, perPost int) (map[int64][]Comment, error) {
+	comments := make(map[int64][]Comment, len(postIds))
+	for _, id := range postIds {
+		comments[id] = []Comment{}
+	}
+	if len(postIds) == 0 {
+		return comments, nil
+	}
+
+	query := `SELECT ` + commentColumns + `
+			FROM (
+				SELECT l.*,ROW_NUMBER() OVER (PARTITION BY l.post_id ORDER BY l.created_at DESC,l.id DESC) AS n
+				FROM comments l
+				WHERE l.post_id=ANY($1) AND l.parent_id IS NULL AND l.deleted_at IS NULL
+				AND NOT ` + hiddenAuthorClause("l.user_id", "$2") + `
+			) a
+			JOIN users b ON a.user_id=b.id
+			WHERE a.n<=$3
+			ORDER BY a.post_id,a.n`
+	rows, err := c.db.QueryContext(ctx, query, pq.Array(postIds), viewerId, perPost)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var comment Comment
+		if err := scanComment(rows, &comment); err != nil {
+			return nil, err
+		}
+		comments[comment.PostId] = append(comments[comment.PostId], comment)
+	}
+	return comments, rows.Err()
+}
+
+// GetThread returns the comment with its replies nested up to depth levels
+// below it, oldest first. It returns ErrorNotFound when viewerId can't see
+// the post or the comment's author.
+func (c *CommentStore) GetThread(ctx context.Context, commentId int64, viewerId int64, depth int) (*Comment, error) {
+	query := `WITH RECURSIVE thread AS (
+				SELECT c.id,c.post_id,c.parent_id,c.user_id,c.content,c.reply_count,c.deleted_at,c.created_at,c.edited_at,c.version,0 AS depth
+				FROM comments c
+				JOIN posts p ON p.id=c.post_id
+				JOIN users u ON u.id=p.user_id
+				WHERE c.id=$1 AND ` + visiblePostsClause("u", "$2") + `
+				AND NOT ` + hiddenAuthorClause("c.user_id", "$2") + `
+				UNION ALL
+				SELECT c.id,c.post_id,c.parent_id,c.user_id,c.content,c.reply_count,c.deleted_at,c.created_at,c.edited_at,c.version,t.depth+1
+				FROM comments c
+				JOIN thread t ON c.parent_id=t.id
+				WHERE t.depth<$3 AND NOT ` + hiddenAuthorClause("c.user_id", "$2") + `
+			)
+			SELECT ` + commentColumns + `
+			FROM thread a
+			JOIN users b ON a.user_id=b.id
+			ORDER BY a.depth,a.created_at,a.id`
+	rows, err := c.db.QueryContext(ctx, query, commentId, viewerId, depth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// rows come level by level so a parent is always seen before its replies
+	var root *Comment
+	byId := make(map[int64]*Comment)
+	for rows.Next() {
+		comment := &Comment{}
+		if err := scanComment(rows, comment); err != nil {
+			return nil, err
+		}
+		byId[comment.Id] = comment
+		if root == nil {
+			root = comment
+			continue
+		}
+		if parent, ok := byId[*comment.ParentId]; ok {
+			parent.Replies = append(parent.Replies, comment)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, ErrorNotFound
+	}
+	return root, nil
 }
 
+// GetById returns ErrorNotFound for deleted comments and comments on posts
+// viewerId isn't allowed to see.
+func (c *CommentStore) GetById(ctx context.Context, commentId int64, viewerId int64) (*Comment, error) {
+	query := `SELECT ` + commentColumns + `
+			FROM comments a
+			JOIN users b ON a.user_id=b.id
+			JOIN posts p ON p.id=a.post_id
+			JOIN users u ON u.id=p.user_id
+			WHERE a.id=$1 AND a.deleted_at IS NULL AND ` + visiblePostsClause("u", "$2")
+	comment := &Comment{}
+	if err := scanComment(c.db.QueryRowContext(ctx, query, commentId, viewerId), comment); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return comment, nil
+}
+
+// Update saves the comment's content as long as nobody changed it since it
+// was read at comment.Version, ErrConflict otherwise.
+func (c *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `UPDATE comments SET content=$1,edited_at=NOW(),version=version+1
+			WHERE id=$2 AND version=$3 AND deleted_at IS NULL
+			RETURNING version,edited_at`
+	err := c.db.QueryRowContext(ctx, query, comment.Content, comment.Id, comment.Version).Scan(&comment.Version, &comment.EditedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Create returns ErrBlocked when the post's author and the commenter blocked
+// one another, ErrorNotFound when the post or the parent comment doesn't
+// exist. A reply's parent has to be a live comment on the same post.
 func (c *CommentStore) Create(ctx context.Context, comment *Comment) error {
-	query := `INSERT INTO comments (post_id,user_id,content) VALUES($1,$2,$3) RETURNING id,created_at`
-	err := c.db.QueryRowContext(ctx, query, comment.PostId, comment.UserId, comment.Content).Scan(&comment.Id, &comment.CreatedAt)
+	query := `INSERT INTO comments (post_id,parent_id,user_id,content)
+			SELECT p.id,$4,$2,$3 FROM posts p WHERE p.id=$1 AND NOT ` + blockedClause("p.user_id", "$2") + `
+			AND ($4::bigint IS NULL OR EXISTS (
+				SELECT 1 FROM comments pc WHERE pc.id=$4 AND pc.post_id=p.id AND pc.deleted_at IS NULL
+			))
+			RETURNING id,created_at`
+	err := c.db.QueryRowContext(ctx, query, comment.PostId, comment.UserId, comment.Content, comment.ParentId).Scan(&comment.Id, &comment.CreatedAt)
 	if err != nil {
-		return err
+		switch err {
+		case sql.ErrNoRows:
+			return c.missingPostOrBlocked(ctx, comment)
+		default:
+			return err
+		}
 	}
 	return nil
 }
+
+func (c *CommentStore) missingPostOrBlocked(ctx context.Context, comment *Comment) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM posts WHERE id=$1)
+			AND ($2::bigint IS NULL OR EXISTS (
+				SELECT 1 FROM comments WHERE id=$2 AND post_id=$1 AND deleted_at IS NULL
+			))`
+	if err := c.db.QueryRowContext(ctx, query, comment.PostId, comment.ParentId).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrorNotFound
+	}
+	return ErrBlocked
+}
+
+// Delete removes a comment. A comment with replies is left as a tombstone
+// so the thread under it stays reachable, and a tombstone whose last reply
+// goes away is removed with it.
+func (c *CommentStore) Delete(ctx context.Context, commentId int64) error {
+	return WithTx(c.db, ctx, func(tx *sql.Tx) error {
+		var replyCount int64
+		var parentId *int64
+		query := `SELECT reply_count,parent_id FROM comments WHERE id=$1 AND deleted_at IS NULL FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, query, commentId).Scan(&replyCount, &parentId); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if replyCount > 0 {
+			query = `UPDATE comments SET content='',deleted_at=NOW() WHERE id=$1`
+			_, err := tx.ExecContext(ctx, query, commentId)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE id=$1`, commentId); err != nil {
+			return err
+		}
+		query = `DELETE FROM comments WHERE id=$1 AND deleted_at IS NOT NULL AND reply_count=0 RETURNING parent_id`
+		for parentId != nil {
+			err := tx.QueryRowContext(ctx, query, *parentId).Scan(&parentId)
+			switch err {
+			case nil:
+			case sql.ErrNoRows:
+				return nil
+			default:
+				return err
+			}
+		}
+		return nil
+	})
+}
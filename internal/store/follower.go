@@ -17,25 +17,255 @@ type Follower struct {
 }
 
 // TODO: pick the userId from token(authintication flow)
+// Follow returns ErrBlocked when either user blocked the other.
 func (f *FollowerStore) Follow(ctx context.Context, follower int64, userId int64) error {
-	query := `INSERT INTO followers(user_id,follower_id) VALUES($1,$2)`
-	_, err := f.db.ExecContext(ctx, query, userId, follower)
+	query := `INSERT INTO followers(user_id,follower_id) SELECT $1,$2 WHERE NOT ` + blockedClause("$1", "$2")
+	res, err := f.db.ExecContext(ctx, query, userId, follower)
 	if err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
 			return ErrConflict
 		}
 		return err
 	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrBlocked
+	}
 	return nil
 
 }
 
 // TODO: pick the userId from token(authintication flow)
+// UnFollow also withdraws a follow request that is still pending.
 func (f *FollowerStore) UnFollow(ctx context.Context, follower int64, userId int64) error {
-	query := `DELETE FROM  followers WHERE user_id=$1 AND follower_id=$2`
-	_, err := f.db.ExecContext(ctx, query, userId, follower)
+	return WithTx(f.db, ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM  followers WHERE user_id=$1 AND follower_id=$2`
+		if _, err := tx.ExecContext(ctx, query, userId, follower); err != nil {
+			return err
+		}
+		query = `DELETE FROM follow_requests WHERE user_id=$1 AND target_id=$2`
+		_, err := tx.ExecContext(ctx, query, userId, follower)
+		return err
+	})
+}
+
+// FollowRequest is a pending follow of a private account.
+type FollowRequest struct {
+	UserId    int64  `json:"user_id"`
+	TargetId  int64  `json:"target_id"`
+	CreatedAt string `json:"created_at"`
+	User      User   `json:"-"`
+}
+
+// RequestFollow asks targetId for approval. ErrConflict means userId already
+// follows targetId or is still waiting for an answer, ErrBlocked that either
+// blocked the other.
+func (f *FollowerStore) RequestFollow(ctx context.Context, userId int64, targetId int64) error {
+	return WithTx(f.db, ctx, func(tx *sql.Tx) error {
+		var following bool
+		var blocked bool
+		query := `SELECT EXISTS (SELECT 1 FROM followers WHERE user_id=$1 AND follower_id=$2),` + blockedClause("$1", "$2")
+		if err := tx.QueryRowContext(ctx, query, userId, targetId).Scan(&following, &blocked); err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+		if following {
+			return ErrConflict
+		}
+		query = `INSERT INTO follow_requests (user_id,target_id) VALUES($1,$2)`
+		if _, err := tx.ExecContext(ctx, query, userId, targetId); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// GetRequests lists the follow requests waiting for targetId, oldest first.
+func (f *FollowerStore) GetRequests(ctx context.Context, targetId int64, p PaginatedFeedQuery) ([]FollowRequest, error) {
+	query := `SELECT r.user_id,r.target_id,r.created_at,` + profileColumns + `
+			FROM follow_requests r JOIN users u ON u.id=r.user_id
+			WHERE r.target_id=$1
+			ORDER BY r.created_at ` + p.Sort + `
+			LIMIT $2 OFFSET $3`
+	rows, err := f.db.QueryContext(ctx, query, targetId, p.Limit, p.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	requests := []FollowRequest{}
+	for rows.Next() {
+		var req FollowRequest
+		err := rows.Scan(append([]any{&req.UserId, &req.TargetId, &req.CreatedAt}, profileFields(&req.User)...)...)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+// ApproveRequest turns the pending request of userId into a follow.
+func (f *FollowerStore) ApproveRequest(ctx context.Context, userId int64, targetId int64) error {
+	return WithTx(f.db, ctx, func(tx *sql.Tx) error {
+		if err := deleteFollowRequest(ctx, tx, userId, targetId); err != nil {
+			return err
+		}
+		query := `INSERT INTO followers (user_id,follower_id) VALUES($1,$2) ON CONFLICT DO NOTHING`
+		_, err := tx.ExecContext(ctx, query, userId, targetId)
+		return err
+	})
+}
+
+func (f *FollowerStore) RejectRequest(ctx context.Context, userId int64, targetId int64) error {
+	return WithTx(f.db, ctx, func(tx *sql.Tx) error {
+		return deleteFollowRequest(ctx, tx, userId, targetId)
+	})
+}
+
+// ApproveAllRequests is used when a private account goes public, nobody has
+// to ask anymore. It returns the ids of the new followers.
+func (f *FollowerStore) ApproveAllRequests(ctx context.Context, targetId int64) ([]int64, error) {
+	var approved []int64
+	err := WithTx(f.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO followers (user_id,follower_id)
+				SELECT user_id,target_id FROM follow_requests WHERE target_id=$1
+				ON CONFLICT DO NOTHING
+				RETURNING user_id`
+		rows, err := tx.QueryContext(ctx, query, targetId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userId int64
+			if err := rows.Scan(&userId); err != nil {
+				return err
+			}
+			approved = append(approved, userId)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		query = `DELETE FROM follow_requests WHERE target_id=$1`
+		_, err = tx.ExecContext(ctx, query, targetId)
+		return err
+	})
+	return approved, err
+}
+
+// GetFollowerIds returns the ids of everyone following userId.
+func (f *FollowerStore) GetFollowerIds(ctx context.Context, userId int64) ([]int64, error) {
+	query := `SELECT user_id FROM followers WHERE follower_id=$1`
+	rows, err := f.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetFollowerCount reads the follower count of userId as it is now, where
+// the cached user may be behind.
+func (f *FollowerStore) GetFollowerCount(ctx context.Context, userId int64) (int64, error) {
+	var count int64
+	query := `SELECT follower_count FROM users WHERE id=$1`
+	if err := f.db.QueryRowContext(ctx, query, userId).Scan(&count); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrorNotFound
+		}
+		return 0, err
+	}
+	return count, nil
+}
+
+func deleteFollowRequest(ctx context.Context, tx *sql.Tx, userId int64, targetId int64) error {
+	query := `DELETE FROM follow_requests WHERE user_id=$1 AND target_id=$2`
+	res, err := tx.ExecContext(ctx, query, userId, targetId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
-	return err
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// FollowEntry is one user in a followers or following list.
+type FollowEntry struct {
+	User
+	FollowedAt     string
+	IsFollowedByMe bool
+}
+
+// GetFollowers lists the users following userId. IsFollowedByMe tells
+// whether viewerId follows each of them.
+func (f *FollowerStore) GetFollowers(ctx context.Context, userId int64, viewerId int64, p PaginatedFeedQuery) ([]FollowEntry, error) {
+	query := `SELECT ` + followEntryColumns + `
+			FROM followers f JOIN users u ON u.id=f.user_id
+			WHERE f.follower_id=$1
+			ORDER BY f.created_at ` + p.Sort + `
+			LIMIT $3 OFFSET $4`
+	return f.getFollowEntries(ctx, query, userId, viewerId, p)
+}
+
+// GetFollowing lists the users userId follows.
+func (f *FollowerStore) GetFollowing(ctx context.Context, userId int64, viewerId int64, p PaginatedFeedQuery) ([]FollowEntry, error) {
+	query := `SELECT ` + followEntryColumns + `
+			FROM followers f JOIN users u ON u.id=f.follower_id
+			WHERE f.user_id=$1
+			ORDER BY f.created_at ` + p.Sort + `
+			LIMIT $3 OFFSET $4`
+	return f.getFollowEntries(ctx, query, userId, viewerId, p)
+}
+
+func (f *FollowerStore) IsFollowing(ctx context.Context, userId int64, targetId int64) (bool, error) {
+	var following bool
+	query := `SELECT EXISTS (SELECT 1 FROM followers WHERE user_id=$1 AND follower_id=$2)`
+	if err := f.db.QueryRowContext(ctx, query, userId, targetId).Scan(&following); err != nil {
+		return false, err
+	}
+	return following, nil
+}
+
+const followEntryColumns = `u.id,u.username,u.created_at,u.display_name,u.bio,u.location,u.website_links,u.avatar_url,u.is_private,
+			u.follower_count,u.following_count,u.post_count,f.created_at,
+			EXISTS (SELECT 1 FROM followers m WHERE m.user_id=$2 AND m.follower_id=u.id)`
+
+func (f *FollowerStore) getFollowEntries(ctx context.Context, query string, userId int64, viewerId int64, p PaginatedFeedQuery) ([]FollowEntry, error) {
+	rows, err := f.db.QueryContext(ctx, query, userId, viewerId, p.Limit, p.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []FollowEntry{}
+	for rows.Next() {
+		var e FollowEntry
+		err := rows.Scan(&e.Id, &e.Username, &e.CreatedAt, &e.DisplayName, &e.Bio, &e.Location, pq.Array(&e.WebsiteLinks), &e.AvatarURL, &e.IsPrivate,
+			&e.FollowerCount, &e.FollowingCount, &e.PostCount, &e.FollowedAt, &e.IsFollowedByMe)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
 }
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// Identity links a user to an account at an external OpenID Connect
+// provider. Subject is the provider's id for that account.
+type Identity struct {
+	Id        int64  `json:"id"`
+	UserId    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"-"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func (i *IdentityStore) GetByProviderSubject(ctx context.Context, provider string, subject string) (*Identity, error) {
+	query := `SELECT id,user_id,provider,subject,email,created_at FROM user_identities
+			WHERE provider=$1 AND subject=$2`
+	identity := &Identity{}
+	err := i.db.QueryRowContext(ctx, query, provider, subject).
+		Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+func (i *IdentityStore) GetByUserId(ctx context.Context, userId int64) ([]Identity, error) {
+	query := `SELECT id,user_id,provider,subject,email,created_at FROM user_identities
+			WHERE user_id=$1 ORDER BY created_at`
+	rows, err := i.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Create links the identity, ErrConflict means the provider account is
+// already linked or the user already has an account at that provider.
+func (i *IdentityStore) Create(ctx context.Context, identity *Identity) error {
+	return WithTx(i.db, ctx, func(tx *sql.Tx) error {
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+func (i *IdentityStore) Delete(ctx context.Context, userId int64, provider string) error {
+	query := `DELETE FROM user_identities WHERE user_id=$1 AND provider=$2`
+	res, err := i.db.ExecContext(ctx, query, userId, provider)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func createIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `INSERT INTO user_identities (user_id,provider,subject,email)
+	VALUES($1,$2,$3,$4) RETURNING id,created_at`
+	err := tx.QueryRowContext(ctx, query, identity.UserId, identity.Provider, identity.Subject, identity.Email).
+		Scan(&identity.Id, &identity.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
@@ -1,39 +1,322 @@
 package store
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type PaginatedFeedQuery struct {
-	Limit  int    `json:"limit" validate:"gte=1"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=100"`
 	Offset int    `json:"offset" validate:"gte=0"`
 	Sort   string `json:"sort" validate:"oneof=asc desc"`
 }
 
 func (p PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error) {
 	query := r.URL.Query()
+	errs := FieldErrors{}
 
 	limit := query.Get("limit")
 	if limit != "" {
 		l, err := strconv.Atoi(limit)
 		if err != nil {
-			return p, nil
+			errs["limit"] = "must be a number"
+		} else {
+			p.Limit = l
 		}
-		p.Limit = l
 	}
 	offset := query.Get("offset")
 	if offset != "" {
 		o, err := strconv.Atoi(offset)
 		if err != nil {
-			return p, nil
+			errs["offset"] = "must be a number"
+		} else {
+			p.Offset = o
 		}
-		p.Offset = o
 	}
 	sort := query.Get("sort")
 
 	if sort != "" {
 		p.Sort = sort
 	}
-	return p, nil
+	return p, errs.orNil()
+}
+
+// FieldErrors tells what is wrong with each query parameter of a request.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, message := range e {
+		fields = append(fields, field+" "+message)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
+
+func (e FieldErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// merge adds the field errors in err to e, other errors are returned as is.
+func (e FieldErrors) merge(err error) error {
+	fieldErrs, ok := err.(FieldErrors)
+	if !ok {
+		return err
+	}
+	for field, message := range fieldErrs {
+		e[field] = message
+	}
+	return nil
+}
+
+// EncodeCursor turns the position of the last row of a page into an opaque
+// token clients send back to get the next page.
+func EncodeCursor(position any) (string, error) {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeCursor(cursor string, position any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// FeedQuery pages through the feed with a cursor, unless the client asks for
+// an offset. The ranked mode orders posts by score instead of by date.
+// Search matches post titles.
+type FeedQuery struct {
+	PaginatedFeedQuery
+	PostFilters
+	Mode      string `json:"mode" validate:"oneof=chronological ranked"`
+	Search    string `json:"search" validate:"max=200"`
+	Cursor    string `json:"cursor"`
+	UseOffset bool   `json:"-"`
+}
+
+func (q FeedQuery) Parse(r *http.Request) (FeedQuery, error) {
+	errs := FieldErrors{}
+	var err error
+	q.PaginatedFeedQuery, err = q.PaginatedFeedQuery.Parse(r)
+	if err := errs.merge(err); err != nil {
+		return q, err
+	}
+	q.PostFilters, err = q.PostFilters.Parse(r)
+	if err := errs.merge(err); err != nil {
+		return q, err
+	}
+	query := r.URL.Query()
+	if mode := query.Get("mode"); mode != "" {
+		q.Mode = mode
+	}
+	q.Search = strings.TrimSpace(query.Get("search"))
+	q.Cursor = query.Get("cursor")
+	q.UseOffset = query.Has("offset")
+	if q.UseOffset && q.Cursor != "" {
+		errs["cursor"] = "can't be used together with offset"
+	}
+	return q, errs.orNil()
+}
+
+// Filtered tells whether the query narrows down the feed.
+func (q FeedQuery) Filtered() bool {
+	return len(q.Tags) > 0 || q.AuthorId != 0 || q.Author != "" || q.Since != nil || q.Until != nil || q.Search != ""
+}
+
+// conditions returns the WHERE conditions for the filters and the search on
+// the posts row aliased p and its author aliased u, appending their values
+// to args.
+func (q FeedQuery) conditions(args *[]any) []string {
+	conds := q.PostFilters.conditions(args)
+	if q.Search != "" {
+		*args = append(*args, likeContains(q.Search))
+		conds = append(conds, "p.title ILIKE $"+strconv.Itoa(len(*args)))
+	}
+	return conds
+}
+
+// FeedPosition is a post's place in the feed, which is ordered by
+// (created_at, id).
+type FeedPosition struct {
+	CreatedAt time.Time `json:"t"`
+	Id        int64     `json:"i"`
+}
+
+// After returns the position the page starts after, nil for the first page.
+func (q FeedQuery) After() (*FeedPosition, error) {
+	if q.UseOffset || q.Cursor == "" {
+		return nil, nil
+	}
+	var position FeedPosition
+	if err := DecodeCursor(q.Cursor, &position); err != nil {
+		return nil, err
+	}
+	return &position, nil
+}
+
+type UserSearchQuery struct {
+	Query  string `json:"q" validate:"required,max=100"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Cursor string `json:"cursor"`
+}
+
+func (q UserSearchQuery) Parse(r *http.Request) (UserSearchQuery, error) {
+	query := r.URL.Query()
+	errs := FieldErrors{}
+
+	q.Query = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(query.Get("q")), "@"))
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			errs["limit"] = "must be a number"
+		} else {
+			q.Limit = l
+		}
+	}
+	q.Cursor = query.Get("cursor")
+	return q, errs.orNil()
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likePrefix escapes the LIKE wildcards in text and matches it as a prefix.
+func likePrefix(text string) string {
+	return likeEscaper.Replace(text) + "%"
+}
+
+// likeContains escapes the LIKE wildcards in text and matches it anywhere.
+func likeContains(text string) string {
+	return "%" + likeEscaper.Replace(text) + "%"
+}
+
+// PostFilters narrows down lists of posts.
+type PostFilters struct {
+	Tags     []string   `json:"tags" validate:"max=10,dive,max=200"`
+	TagMode  string     `json:"tag_mode" validate:"oneof=any all"`
+	AuthorId int64      `json:"author_id" validate:"gte=0"`
+	Author   string     `json:"author" validate:"max=100"`
+	Since    *time.Time `json:"since"`
+	Until    *time.Time `json:"until"`
+}
+
+func (f PostFilters) Parse(r *http.Request) (PostFilters, error) {
+	query := r.URL.Query()
+	errs := FieldErrors{}
+
+	if tags := query.Get("tags"); tags != "" {
+		f.Tags = nil
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				f.Tags = append(f.Tags, tag)
+			}
+		}
+	}
+	if tagMode := query.Get("tag_mode"); tagMode != "" {
+		f.TagMode = tagMode
+	}
+	if authorId := query.Get("author_id"); authorId != "" {
+		a, err := strconv.ParseInt(authorId, 10, 64)
+		if err != nil {
+			errs["author_id"] = "must be a user id"
+		} else {
+			f.AuthorId = a
+		}
+	}
+	f.Author = strings.TrimPrefix(strings.TrimSpace(query.Get("author")), "@")
+	for _, param := range []struct {
+		name string
+		dst  **time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			errs[param.name] = "must be an RFC 3339 time or a YYYY-MM-DD date"
+			continue
+		}
+		*param.dst = &t
+	}
+	if f.Since != nil && f.Until != nil && !f.Since.Before(*f.Until) {
+		errs["until"] = "must be after since"
+	}
+	return f, errs.orNil()
+}
+
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// conditions returns the WHERE conditions for the filters on the posts row
+// aliased p and its author aliased u, appending their values to args.
+func (f PostFilters) conditions(args *[]any) []string {
+	var conds []string
+	param := func(value any) string {
+		*args = append(*args, value)
+		return "$" + strconv.Itoa(len(*args))
+	}
+	if len(f.Tags) > 0 {
+		op := "&&"
+		if f.TagMode == "all" {
+			op = "@>"
+		}
+		conds = append(conds, "p.tags "+op+" "+param(pq.Array(f.Tags))+"::varchar[]")
+	}
+	if f.AuthorId != 0 {
+		conds = append(conds, "p.user_id="+param(f.AuthorId))
+	}
+	if f.Author != "" {
+		conds = append(conds, "u.username="+param(f.Author))
+	}
+	if f.Since != nil {
+		conds = append(conds, "p.created_at>="+param(*f.Since))
+	}
+	if f.Until != nil {
+		conds = append(conds, "p.created_at<"+param(*f.Until))
+	}
+	return conds
+}
+
+type PostSearchQuery struct {
+	PaginatedFeedQuery
+	PostFilters
+	Query string `json:"q" validate:"max=200"`
+}
+
+func (q PostSearchQuery) Parse(r *http.Request) (PostSearchQuery, error) {
+	errs := FieldErrors{}
+	var err error
+	q.PaginatedFeedQuery, err = q.PaginatedFeedQuery.Parse(r)
+	if err := errs.merge(err); err != nil {
+		return q, err
+	}
+	q.PostFilters, err = q.PostFilters.Parse(r)
+	if err := errs.merge(err); err != nil {
+		return q, err
+	}
+	q.Query = strings.TrimSpace(r.URL.Query().Get("q"))
+	return q, errs.orNil()
 }
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
@@ -18,14 +21,23 @@ type Post struct {
 	CreatedAt string    `json:"created_at"`
 	UpdatedAt string    `json:"updated_at"`
 	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	User      Author    `json:"user"`
+	Reactions
 }
 
+// PostWithMetaData is a post as the feed shows it. Comments only has the
+// latest few comments.
 type PostWithMetaData struct {
 	Post
 	CommentCount int `json:"comment_count"`
+	// LikedByMe is true when the viewer reacted to the post in any way
+	LikedByMe bool `json:"liked_by_me"`
 }
 
+// feedCommentCount counts the comments of the post aliased p, leaving out
+// tombstones.
+const feedCommentCount = `(SELECT COUNT(*) FROM comments c WHERE c.post_id=p.id AND c.deleted_at IS NULL)`
+
 type PostStore struct {
 	db *sql.DB
 }
@@ -47,11 +59,24 @@ func (p *PostStore) Create(ctx context.Context, post *Post) error {
 	return nil
 }
 
-func (p *PostStore) GetPostById(ctx context.Context, postId int64) (*Post, error) {
-	query := `SELECT id,title,content,tags,user_id,created_at,updated_at,version FROM posts where id=$1`
+// visiblePostsClause is the WHERE condition that hides posts of private
+// accounts from everyone but the author and approved followers, and posts
+// between users where one blocked the other. author is the users row joined on
+// the post's user_id, viewer the parameter holding the viewing user's id.
+func visiblePostsClause(author string, viewer string) string {
+	return `((` + author + `.is_private=false OR ` + author + `.id=` + viewer + `
+			OR EXISTS (SELECT 1 FROM followers vf WHERE vf.user_id=` + viewer + ` AND vf.follower_id=` + author + `.id))
+			AND NOT ` + blockedClause(author+".id", viewer) + `)`
+}
+
+// GetPostById returns ErrorNotFound for posts viewerId isn't allowed to see.
+func (p *PostStore) GetPostById(ctx context.Context, postId int64, viewerId int64) (*Post, error) {
+	query := `SELECT p.id,p.title,p.content,p.tags,p.user_id,p.created_at,p.updated_at,p.version
+			FROM posts p JOIN users u ON u.id=p.user_id
+			WHERE p.id=$1 AND ` + visiblePostsClause("u", "$2")
 	var post Post
 	err := p.db.
-		QueryRowContext(ctx, query, postId).
+		QueryRowContext(ctx, query, postId, viewerId).
 		Scan(&post.Id, &post.Title, &post.Content, pq.Array(&post.Tags), &post.UserId, &post.CreatedAt, &post.UpdatedAt, &post.Version)
 	if err != nil {
 		switch {
@@ -95,30 +120,269 @@ func (p *PostStore) UpdatePostById(ctx context.Context, post *Post) error {
 	return nil
 }
 
-func (p *PostStore) GetUserFeedPosts(ctx context.Context, userId int64, filters PaginatedFeedQuery) ([]PostWithMetaData, error) {
+// GetUserFeedPosts returns the posts of userId and the users they follow.
+// Unless q.UseOffset is set it pages by (created_at, id) and returns the
+// cursor of the next page, empty on the last one.
+func (p *PostStore) GetUserFeedPosts(ctx context.Context, userId int64, q FeedQuery) ([]PostWithMetaData, string, error) {
+	source := `(p.user_id=$1 OR EXISTS (SELECT 1 FROM followers f WHERE f.user_id=$1 AND f.follower_id=p.user_id))`
+	return p.feedPosts(ctx, userId, q, source)
+}
+
+// GetTimelinePosts is GetUserFeedPosts for a cached timeline: the posts in
+// postIds, plus the posts of followed accounts with more than
+// celebrityThreshold followers, which never get pushed to timelines. Unless
+// postIds are complete, the rest of the timeline after the cursor, those
+// posts stop at the oldest of postIds, as the timeline wasn't read further.
+func (p *PostStore) GetTimelinePosts(ctx context.Context, userId int64, postIds []int64, complete bool, celebrityThreshold int64, q FeedQuery) ([]PostWithMetaData, string, error) {
+	source := `(p.id=ANY($2) OR (u.follower_count>$3
+				AND EXISTS (SELECT 1 FROM followers f WHERE f.user_id=$1 AND f.follower_id=p.user_id)
+				AND ($4 OR (p.created_at,p.id)>=(SELECT fp.created_at,fp.id FROM posts fp WHERE fp.id=ANY($2) ORDER BY fp.created_at,fp.id LIMIT 1))))`
+	return p.feedPosts(ctx, userId, q, source, pq.Array(postIds), celebrityThreshold, complete)
+}
+
+// feedPosts pages through the posts matching source, a condition on the post
+// p and its author u, and the filters of q, as seen by userId. $1 is userId,
+// sourceArgs follow it.
+func (p *PostStore) feedPosts(ctx context.Context, userId int64, q FeedQuery, source string, sourceArgs ...any) ([]PostWithMetaData, string, error) {
+	args := append([]any{userId}, sourceArgs...)
+	conds := append([]string{source, visiblePostsClause("u", "$1"), "NOT " + hiddenAuthorClause("p.user_id", "$1")}, q.conditions(&args)...)
+	after, err := q.After()
+	if err != nil {
+		return nil, "", err
+	}
+	keyset := ""
+	if after != nil {
+		op := "<"
+		if q.Sort == "asc" {
+			op = ">"
+		}
+		args = append(args, after.CreatedAt, after.Id)
+		keyset = `AND (p.created_at,p.id) ` + op + ` ($` + strconv.Itoa(len(args)-1) + `,$` + strconv.Itoa(len(args)) + `)`
+	}
+	page := ``
+	if q.UseOffset {
+		args = append(args, q.Limit, q.Offset)
+		page = `LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	} else {
+		// one more row than asked for tells whether there is a next page
+		args = append(args, q.Limit+1)
+		page = `LIMIT $` + strconv.Itoa(len(args))
+	}
+
 	query := `SELECT
-				p.id,p.title,p.user_id,p.content,p.tags,p.created_at,COUNT(distinct c.id) AS comment_count
-				FROM Posts p
-				JOIN COMMENTS c ON c.post_id=p.id
-				JOIN followers f ON p.user_id=f.follower_id OR p.user_id=$1
-				WHERE f.user_id=$1 OR p.user_id=$1
-				GROUP BY p.id
-				ORDER BY p.created_at ` + filters.Sort +
-		` LIMIT $2
-				OFFSET $3
-				`
-	var posts []PostWithMetaData
-	rows, err := p.db.QueryContext(ctx, query, userId, filters.Limit, filters.Offset)
+				p.id,p.title,p.user_id,p.content,p.tags,p.created_at,` + feedCommentCount + ` AS comment_count,
+				u.username,u.display_name,u.avatar_url
+				FROM posts p
+				JOIN users u ON u.id=p.user_id
+				WHERE ` + strings.Join(conds, " AND ") + `
+				` + keyset + `
+				ORDER BY p.created_at ` + q.Sort + `,p.id ` + q.Sort + `
+				` + page
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	defer rows.Close()
+	posts := []PostWithMetaData{}
 	for rows.Next() {
 		var post PostWithMetaData
-		err := rows.Scan(&post.Id, &post.Title, &post.UserId, &post.Content, pq.Array(&post.Tags), &post.CreatedAt, &post.CommentCount)
+		err := rows.Scan(&post.Id, &post.Title, &post.UserId, &post.Content, pq.Array(&post.Tags), &post.CreatedAt, &post.CommentCount,
+			&post.User.Username, &post.User.DisplayName, &post.User.AvatarURL)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		post.User.Id = post.UserId
+		if !q.UseOffset && len(posts) == q.Limit {
+			next, err := feedCursor(posts[len(posts)-1].Post)
+			return posts, next, err
 		}
 		posts = append(posts, post)
 	}
-	return posts, nil
+	return posts, "", rows.Err()
+}
+
+func feedCursor(post Post) (string, error) {
+	position, err := post.Position()
+	if err != nil {
+		return "", err
+	}
+	return EncodeCursor(position)
+}
+
+// Position is where the post is in the feed.
+func (p *Post) Position() (FeedPosition, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, p.CreatedAt)
+	if err != nil {
+		return FeedPosition{}, err
+	}
+	return FeedPosition{CreatedAt: createdAt, Id: p.Id}, nil
+}
+
+// FeedCandidate is a post considered for the ranked feed, with what the
+// ranking looks at.
+type FeedCandidate struct {
+	PostWithMetaData
+	PostedAt      time.Time
+	ReactionCount int
+	// Affinity counts the viewer's comments and reactions on the author's posts
+	Affinity int
+	// TagOverlap counts the post's tags the viewer posted or engaged with
+	TagOverlap int
+	Followed   bool
+}
+
+// GetFeedCandidates returns the newest limit posts since the given time that
+// viewerId can see and that match the filters of q, from themselves, the
+// accounts they follow, and anyone else whose post got at least popularMin
+// comments and reactions.
+func (p *PostStore) GetFeedCandidates(ctx context.Context, viewerId int64, q FeedQuery, since time.Time, popularMin int, limit int) ([]FeedCandidate, error) {
+	args := []any{viewerId, since, popularMin, limit}
+	conds := append([]string{"p.created_at>=$2", visiblePostsClause("u", "$1"), "NOT " + hiddenAuthorClause("p.user_id", "$1")}, q.conditions(&args)...)
+	query := `WITH interactions AS (
+				SELECT post_id FROM comments WHERE user_id=$1
+				UNION ALL
+				SELECT post_id FROM post_reactions WHERE user_id=$1
+			),
+			affinity AS (
+				SELECT ip.user_id AS author_id,COUNT(*) AS interactions
+				FROM interactions i JOIN posts ip ON ip.id=i.post_id
+				GROUP BY ip.user_id
+			),
+			history_tags AS (
+				SELECT DISTINCT unnest(hp.tags) AS tag FROM posts hp
+				WHERE hp.user_id=$1 OR hp.id IN (SELECT post_id FROM interactions)
+			),
+			candidates AS (
+				SELECT p.id,p.title,p.user_id,p.content,p.tags,p.created_at,u.username,u.display_name,u.avatar_url,
+				` + feedCommentCount + ` AS comment_count,
+				(SELECT COUNT(*) FROM post_reactions r WHERE r.post_id=p.id) AS reaction_count,
+				(p.user_id=$1 OR EXISTS (SELECT 1 FROM followers f WHERE f.user_id=$1 AND f.follower_id=p.user_id)) AS followed
+				FROM posts p
+				JOIN users u ON u.id=p.user_id
+				WHERE ` + strings.Join(conds, " AND ") + `
+			)
+			SELECT c.id,c.title,c.user_id,c.content,c.tags,c.created_at,c.created_at,c.username,c.display_name,c.avatar_url,
+			c.comment_count,c.reaction_count,
+			COALESCE(a.interactions,0),
+			(SELECT COUNT(*) FROM unnest(c.tags) t WHERE t IN (SELECT tag FROM history_tags)),
+			c.followed
+			FROM candidates c
+			LEFT JOIN affinity a ON a.author_id=c.user_id
+			WHERE c.followed OR c.comment_count+c.reaction_count>=$3
+			ORDER BY c.created_at DESC,c.id DESC
+			LIMIT $4`
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	candidates := []FeedCandidate{}
+	for rows.Next() {
+		var c FeedCandidate
+		err := rows.Scan(&c.Id, &c.Title, &c.UserId, &c.Content, pq.Array(&c.Tags), &c.CreatedAt, &c.PostedAt,
+			&c.User.Username, &c.User.DisplayName, &c.User.AvatarURL, &c.CommentCount, &c.ReactionCount,
+			&c.Affinity, &c.TagOverlap, &c.Followed)
+		if err != nil {
+			return nil, err
+		}
+		c.User.Id = c.UserId
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// GetTimelineEntries returns the newest limit posts that belong on the cached
+// timeline of userId: their own and those of the accounts they follow that
+// have at most celebrityThreshold followers.
+func (p *PostStore) GetTimelineEntries(ctx context.Context, userId int64, celebrityThreshold int64, limit int) ([]FeedPosition, error) {
+	query := `SELECT p.id,p.created_at
+			FROM posts p
+			JOIN users u ON u.id=p.user_id
+			WHERE p.user_id=$1 OR (u.follower_count<=$2
+				AND EXISTS (SELECT 1 FROM followers f WHERE f.user_id=$1 AND f.follower_id=p.user_id))
+			ORDER BY p.created_at DESC,p.id DESC
+			LIMIT $3`
+	return p.positions(ctx, query, userId, celebrityThreshold, limit)
+}
+
+// GetPositionsByUserId returns where the newest limit posts of userId are in
+// the feed.
+func (p *PostStore) GetPositionsByUserId(ctx context.Context, userId int64, limit int) ([]FeedPosition, error) {
+	query := `SELECT id,created_at FROM posts WHERE user_id=$1 ORDER BY created_at DESC,id DESC LIMIT $2`
+	return p.positions(ctx, query, userId, limit)
+}
+
+func (p *PostStore) positions(ctx context.Context, query string, args ...any) ([]FeedPosition, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	positions := []FeedPosition{}
+	for rows.Next() {
+		var position FeedPosition
+		if err := rows.Scan(&position.Id, &position.CreatedAt); err != nil {
+			return nil, err
+		}
+		positions = append(positions, position)
+	}
+	return positions, rows.Err()
+}
+
+// PostSearchResult is a post matching a search. Highlights are HTML escaped
+// text with the matched words wrapped in <mark>, they are empty when
+// searching by filters only.
+type PostSearchResult struct {
+	Post
+	Rank             float64 `json:"rank"`
+	TitleHighlight   string  `json:"title_highlight"`
+	ContentHighlight string  `json:"content_highlight"`
+}
+
+// htmlEscape escapes the text in column, so the only markup in a highlight
+// is the <mark> around the matches.
+func htmlEscape(column string) string {
+	return `replace(replace(replace(replace(replace(` + column + `,'&','&amp;'),'<','&lt;'),'>','&gt;'),'"','&quot;'),'''','&#39;')`
+}
+
+// Search matches q.Query against the title and content of posts viewerId can
+// see, best matches first. Without a query the filters alone pick the posts,
+// ordered by date.
+func (p *PostStore) Search(ctx context.Context, viewerId int64, q PostSearchQuery) ([]PostSearchResult, error) {
+	args := []any{viewerId}
+	conds := append([]string{visiblePostsClause("u", "$1")}, q.PostFilters.conditions(&args)...)
+	columns := `0::real AS rank,'' AS title_highlight,'' AS content_highlight`
+	order := `p.created_at ` + q.Sort + `,p.id ` + q.Sort
+	if q.Query != "" {
+		args = append(args, q.Query)
+		tsQuery := `websearch_to_tsquery('english',$` + strconv.Itoa(len(args)) + `)`
+		conds = append(conds, `p.search_vector @@ `+tsQuery)
+		columns = `ts_rank(p.search_vector,` + tsQuery + `) AS rank,
+				ts_headline('english',` + htmlEscape("p.title") + `,` + tsQuery + `,'HighlightAll=true,StartSel=<mark>,StopSel=</mark>') AS title_highlight,
+				ts_headline('english',` + htmlEscape("p.content") + `,` + tsQuery + `,'MaxFragments=2,MaxWords=30,MinWords=10,StartSel=<mark>,StopSel=</mark>') AS content_highlight`
+		order = `rank DESC,p.created_at DESC,p.id DESC`
+	}
+	args = append(args, q.Limit, q.Offset)
+
+	query := `SELECT p.id,p.title,p.user_id,p.content,p.tags,p.created_at,p.updated_at,p.version,` + columns + `
+			FROM posts p JOIN users u ON u.id=p.user_id
+			WHERE ` + strings.Join(conds, " AND ") + `
+			ORDER BY ` + order + `
+			LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []PostSearchResult{}
+	for rows.Next() {
+		var res PostSearchResult
+		err := rows.Scan(&res.Id, &res.Title, &res.UserId, &res.Content, pq.Array(&res.Tags), &res.CreatedAt, &res.UpdatedAt, &res.Version,
+			&res.Rank, &res.TitleHighlight, &res.ContentHighlight)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
 }
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+var ReactionKinds = []string{"like", "love", "laugh", "wow", "sad", "angry"}
+
+func IsReactionKind(kind string) bool {
+	return slices.Contains(ReactionKinds, kind)
+}
+
+// Reactions are counted per kind when read instead of being kept in counter
+// columns, so concurrent reactions can't make them drift.
+type Reactions struct {
+	Counts     map[string]int `json:"reactions"`
+	MyReaction *string        `json:"my_reaction"`
+}
+
+type ReactionStore struct {
+	db *sql.DB
+}
+
+// Set reacts to the post with kind, replacing any other reaction of the user.
+// Setting the same kind again changes nothing.
+func (r *ReactionStore) Set(ctx context.Context, postId int64, userId int64, kind string) error {
+	query := `INSERT INTO post_reactions (post_id,user_id,kind) VALUES($1,$2,$3)
+			ON CONFLICT (post_id,user_id) DO UPDATE SET kind=EXCLUDED.kind,created_at=NOW()
+			WHERE post_reactions.kind<>EXCLUDED.kind`
+	_, err := r.db.ExecContext(ctx, query, postId, userId, kind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes the user's reaction if it is of kind. Removing a reaction
+// that isn't there is not an error.
+func (r *ReactionStore) Delete(ctx context.Context, postId int64, userId int64, kind string) error {
+	query := `DELETE FROM post_reactions WHERE post_id=$1 AND user_id=$2 AND kind=$3`
+	_, err := r.db.ExecContext(ctx, query, postId, userId, kind)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetByPostIds returns the reactions of each post as seen by viewerId, every
+// post asked for gets an entry.
+func (r *ReactionStore) GetByPostIds(ctx context.Context, postIds []int64, viewerId int64) (map[int64]*Reactions, error) {
+	reactions := make(map[int64]*Reactions, len(postIds))
+	for _, id := range postIds {
+		reactions[id] = &Reactions{Counts: map[string]int{}}
+	}
+	if len(postIds) == 0 {
+		return reactions, nil
+	}
+
+	query := `SELECT post_id,kind,COUNT(*),bool_or(user_id=$2)
+			FROM post_reactions
+			WHERE post_id=ANY($1)
+			GROUP BY post_id,kind`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(postIds), viewerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var postId int64
+		var kind string
+		var count int
+		var mine bool
+		if err := rows.Scan(&postId, &kind, &count, &mine); err != nil {
+			return nil, err
+		}
+		reactions[postId].Counts[kind] = count
+		if mine {
+			reactions[postId].MyReaction = &kind
+		}
+	}
+	return reactions, rows.Err()
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RefreshToken is one link of a rotation chain. Every token issued from the
+// same login shares a FamilyId so that the whole chain can be revoked at once.
+type RefreshToken struct {
+	Id        int64     `json:"id"`
+	UserId    int64     `json:"user_id"`
+	FamilyId  string    `json:"family_id"`
+	Token     string    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	CreatedAt string    `json:"created_at"`
+}
+
+type RefreshTokenStore struct {
+	db *sql.DB
+}
+
+func (r *RefreshTokenStore) Create(ctx context.Context, token *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (user_id,family_id,token,expiry)
+	VALUES($1,$2,$3,$4) RETURNING id,created_at`
+	err := r.db.QueryRowContext(ctx, query, token.UserId, token.FamilyId, token.Token, token.Expiry).Scan(&token.Id, &token.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RefreshTokenStore) GetByToken(ctx context.Context, token string) (*RefreshToken, error) {
+	query := `SELECT id,user_id,family_id,expiry,created_at FROM refresh_tokens
+			WHERE token=$1 AND revoked_at IS NULL AND expiry>NOW()`
+	refreshToken := &RefreshToken{}
+	err := r.db.QueryRowContext(ctx, query, token).Scan(&refreshToken.Id, &refreshToken.UserId, &refreshToken.FamilyId, &refreshToken.Expiry, &refreshToken.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return refreshToken, nil
+}
+
+// Rotate revokes the token and stores next in the same family. Presenting a
+// token that was already rotated means it leaked, so the whole family is
+// revoked and ErrTokenReused is returned.
+func (r *RefreshTokenStore) Rotate(ctx context.Context, token string, next *RefreshToken) error {
+	err := WithTx(r.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT user_id,family_id,revoked_at IS NOT NULL,expiry<NOW()
+				FROM refresh_tokens WHERE token=$1 FOR UPDATE`
+		var revoked, expired bool
+		err := tx.QueryRowContext(ctx, query, token).Scan(&next.UserId, &next.FamilyId, &revoked, &expired)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if revoked {
+			return ErrTokenReused
+		}
+		if expired {
+			return ErrorNotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at=NOW() WHERE token=$1`, token); err != nil {
+			return err
+		}
+		query = `INSERT INTO refresh_tokens (user_id,family_id,token,expiry)
+		VALUES($1,$2,$3,$4) RETURNING id,created_at`
+		return tx.QueryRowContext(ctx, query, next.UserId, next.FamilyId, next.Token, next.Expiry).Scan(&next.Id, &next.CreatedAt)
+	})
+	// the revocation has to outlive the rolled back transaction above
+	if errors.Is(err, ErrTokenReused) {
+		if err := r.RevokeFamily(ctx, next.FamilyId); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// RevokeFamily revokes every token of the family and the session it belongs to.
+func (r *RefreshTokenStore) RevokeFamily(ctx context.Context, familyId string) error {
+	return WithTx(r.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE sessions SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, query, familyId); err != nil {
+			return err
+		}
+		return revokeRefreshTokenFamily(ctx, tx, familyId)
+	})
+}
+
+func revokeRefreshTokenFamily(ctx context.Context, tx *sql.Tx, familyId string) error {
+	query := `UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=$1 AND revoked_at IS NULL`
+	_, err := tx.ExecContext(ctx, query, familyId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
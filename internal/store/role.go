@@ -3,7 +3,6 @@ package store
 import (
 	"context"
 	"database/sql"
-	"fmt"
 )
 
 type Role struct {
@@ -11,6 +10,7 @@ type Role struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Level       int    `json:"level"`
+	RequiresMFA bool   `json:"requires_mfa"`
 }
 
 type RoleStore struct {
@@ -18,14 +18,27 @@ type RoleStore struct {
 }
 
 func (r *RoleStore) GetByName(ctx context.Context, name string) (*Role, error) {
-	query := `SELECT name,id,description,level FROM roles WHERE name=$1`
+	query := `SELECT name,id,description,level,requires_mfa FROM roles WHERE name=$1`
 	role := &Role{}
-	fmt.Printf("hit here")
-	err := r.db.QueryRowContext(ctx, query, name).Scan(&role.Name, &role.Id, &role.Description, &role.Level)
+	err := r.db.QueryRowContext(ctx, query, name).Scan(&role.Name, &role.Id, &role.Description, &role.Level, &role.RequiresMFA)
 	if err != nil {
-		fmt.Print("new err:", err)
-		return nil, err
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
 	}
-	fmt.Printf("role in get", &role)
 	return role, nil
 }
+
+// SetMFARequirement makes two factor authentication mandatory for every role
+// at or above minLevel. A minLevel of zero or less lifts the requirement.
+func (r *RoleStore) SetMFARequirement(ctx context.Context, minLevel int) error {
+	query := `UPDATE roles SET requires_mfa=($1>0 AND level>=$1)`
+	_, err := r.db.ExecContext(ctx, query, minLevel)
+	if err != nil {
+		return err
+	}
+	return nil
+}
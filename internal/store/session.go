@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Session is one login of a user on a device. Its id is also the FamilyId of
+// the refresh tokens issued for it and the sid claim of its access tokens.
+type Session struct {
+	Id         string `json:"id"`
+	UserId     int64  `json:"user_id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `INSERT INTO sessions (id,user_id,user_agent,ip)
+	VALUES($1,$2,$3,$4) RETURNING created_at,last_seen_at`
+	err := s.db.QueryRowContext(ctx, query, session.Id, session.UserId, session.UserAgent, session.IP).Scan(&session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *SessionStore) GetByUserId(ctx context.Context, userId int64) ([]Session, error) {
+	query := `SELECT id,user_id,user_agent,ip,created_at,last_seen_at FROM sessions
+			WHERE user_id=$1 AND revoked_at IS NULL
+			ORDER BY last_seen_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.Id, &session.UserId, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Touch records activity on a session, ErrorNotFound means it was revoked.
+// It runs on every authenticated request, so last_seen_at and the ip are
+// only written once a minute and the rest of the time it is a read.
+func (s *SessionStore) Touch(ctx context.Context, sessionId string, ip string) error {
+	query := `WITH touched AS (
+				UPDATE sessions SET last_seen_at=NOW(),ip=$1
+				WHERE id=$2 AND revoked_at IS NULL AND last_seen_at<NOW()-interval '1 minute'
+			)
+			SELECT EXISTS (SELECT 1 FROM sessions WHERE id=$2 AND revoked_at IS NULL)`
+	var active bool
+	if err := s.db.QueryRowContext(ctx, query, ip, sessionId).Scan(&active); err != nil {
+		return err
+	}
+	if !active {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (s *SessionStore) Revoke(ctx context.Context, sessionId string, userId int64) error {
+	return WithTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE sessions SET revoked_at=NOW() WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, sessionId, userId)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrorNotFound
+		}
+		return revokeRefreshTokenFamily(ctx, tx, sessionId)
+	})
+}
+
+func (s *SessionStore) RevokeAll(ctx context.Context, userId int64) error {
+	return WithTx(s.db, ctx, func(tx *sql.Tx) error {
+		return revokeUserSessions(ctx, tx, userId)
+	})
+}
+
+func revokeUserSessions(ctx context.Context, tx *sql.Tx, userId int64) error {
+	query := `UPDATE sessions SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		return err
+	}
+	query = `UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		return err
+	}
+	return nil
+}
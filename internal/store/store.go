@@ -8,17 +8,25 @@ import (
 )
 
 var (
-	ErrorNotFound = errors.New("record not round")
-	ErrConflict   = errors.New("record already exists")
+	ErrorNotFound    = errors.New("record not round")
+	ErrConflict      = errors.New("record already exists")
+	ErrTokenReused   = errors.New("refresh token already used")
+	ErrBlocked       = errors.New("user is blocked")
+	ErrInvalidCursor = errors.New("invalid cursor")
 )
 
 type Store struct {
 	Post interface {
 		Create(context.Context, *Post) error
-		GetPostById(ctx context.Context, postId int64) (*Post, error)
+		GetPostById(ctx context.Context, postId int64, viewerId int64) (*Post, error)
 		DeletePostById(ctx context.Context, postId int64) error
 		UpdatePostById(ctx context.Context, post *Post) error
-		GetUserFeedPosts(ctx context.Context, userId int64, p PaginatedFeedQuery) ([]PostWithMetaData, error)
+		GetUserFeedPosts(ctx context.Context, userId int64, q FeedQuery) ([]PostWithMetaData, string, error)
+		GetTimelinePosts(ctx context.Context, userId int64, postIds []int64, complete bool, celebrityThreshold int64, q FeedQuery) ([]PostWithMetaData, string, error)
+		GetTimelineEntries(ctx context.Context, userId int64, celebrityThreshold int64, limit int) ([]FeedPosition, error)
+		GetPositionsByUserId(ctx context.Context, userId int64, limit int) ([]FeedPosition, error)
+		GetFeedCandidates(ctx context.Context, viewerId int64, q FeedQuery, since time.Time, popularMin int, limit int) ([]FeedCandidate, error)
+		Search(ctx context.Context, viewerId int64, q PostSearchQuery) ([]PostSearchResult, error)
 	}
 	User interface {
 		Create(context.Context, *sql.Tx, *User) error
@@ -26,28 +34,112 @@ type Store struct {
 		GetByEmail(context.Context, string) (*User, error)
 		CreateAndInvite(context.Context, *User, string, time.Duration) error
 		Activate(context.Context, string) error
+		RegenerateInvitation(context.Context, string, string, time.Duration) (*User, error)
+		DeleteExpiredInvitations(context.Context) (int64, error)
+		DeleteUnactivated(context.Context, time.Duration) (int64, error)
 		Delete(context.Context, int64) error
+		CreatePasswordReset(context.Context, int64, string, time.Duration) error
+		ResetPassword(context.Context, string, *User) error
+		SetTOTPSecret(context.Context, int64, string) error
+		UseTOTPStep(context.Context, int64, int64) error
+		EnableTOTP(context.Context, int64, []string) error
+		DisableTOTP(context.Context, int64) error
+		ReplaceRecoveryCodes(context.Context, int64, []string) error
+		UseRecoveryCode(context.Context, int64, string) error
+		Lock(context.Context, int64, time.Time) error
+		Unlock(context.Context, int64) error
+		UpdateRole(context.Context, int64, int64) error
+		UpdateProfile(context.Context, int64, *Profile) error
+		Search(context.Context, int64, UserSearchQuery) ([]UserSearchResult, string, error)
+		CreateEmailChange(context.Context, int64, string, string, time.Duration) error
+		ConfirmEmailChange(context.Context, string) (*User, error)
+		CreateWithIdentity(context.Context, *User, *Identity, string, time.Duration) error
 	}
 	Comment interface {
-		GetCommentByPostId(context.Context, int64) ([]Comment, error)
+		GetCommentByPostId(context.Context, int64, int64, PaginatedFeedQuery) ([]Comment, error)
+		GetThread(context.Context, int64, int64, int) (*Comment, error)
+		GetLatestByPostIds(context.Context, []int64, int64, int) (map[int64][]Comment, error)
+		GetById(context.Context, int64, int64) (*Comment, error)
 		Create(context.Context, *Comment) error
+		Update(context.Context, *Comment) error
+		Delete(context.Context, int64) error
 	}
 	Follower interface {
 		Follow(context.Context, int64, int64) error
 		UnFollow(context.Context, int64, int64) error
+		GetFollowers(context.Context, int64, int64, PaginatedFeedQuery) ([]FollowEntry, error)
+		GetFollowing(context.Context, int64, int64, PaginatedFeedQuery) ([]FollowEntry, error)
+		IsFollowing(context.Context, int64, int64) (bool, error)
+		RequestFollow(context.Context, int64, int64) error
+		GetRequests(context.Context, int64, PaginatedFeedQuery) ([]FollowRequest, error)
+		ApproveRequest(context.Context, int64, int64) error
+		RejectRequest(context.Context, int64, int64) error
+		ApproveAllRequests(context.Context, int64) ([]int64, error)
+		GetFollowerIds(context.Context, int64) ([]int64, error)
+		GetFollowerCount(context.Context, int64) (int64, error)
 	}
 	Role interface {
 		GetByName(context.Context, string) (*Role, error)
+		SetMFARequirement(context.Context, int) error
+	}
+	RefreshToken interface {
+		Create(context.Context, *RefreshToken) error
+		GetByToken(context.Context, string) (*RefreshToken, error)
+		Rotate(context.Context, string, *RefreshToken) error
+		RevokeFamily(context.Context, string) error
+	}
+	Session interface {
+		Create(context.Context, *Session) error
+		GetByUserId(context.Context, int64) ([]Session, error)
+		Touch(context.Context, string, string) error
+		Revoke(context.Context, string, int64) error
+		RevokeAll(context.Context, int64) error
+	}
+	APIKey interface {
+		Create(context.Context, *APIKey) error
+		GetByToken(context.Context, string) (*APIKey, error)
+		GetByUserId(context.Context, int64) ([]APIKey, error)
+		Delete(context.Context, int64, int64) error
+		Touch(context.Context, int64, string) error
+	}
+	Reaction interface {
+		Set(context.Context, int64, int64, string) error
+		Delete(context.Context, int64, int64, string) error
+		GetByPostIds(context.Context, []int64, int64) (map[int64]*Reactions, error)
+	}
+	Block interface {
+		Block(context.Context, int64, int64) error
+		Unblock(context.Context, int64, int64) error
+		GetBlocked(context.Context, int64, PaginatedFeedQuery) ([]Relation, error)
+		IsBlocked(context.Context, int64, int64) (bool, error)
+	}
+	Mute interface {
+		Mute(context.Context, int64, int64) error
+		Unmute(context.Context, int64, int64) error
+		GetMuted(context.Context, int64, PaginatedFeedQuery) ([]Relation, error)
+	}
+	Identity interface {
+		GetByProviderSubject(context.Context, string, string) (*Identity, error)
+		GetByUserId(context.Context, int64) ([]Identity, error)
+		Create(context.Context, *Identity) error
+		Delete(context.Context, int64, string) error
 	}
 }
 
 func NewStore(db *sql.DB) Store {
 	return Store{
-		Post:     &PostStore{db},
-		User:     &UserStore{db},
-		Comment:  &CommentStore{db},
-		Follower: &FollowerStore{db},
-		Role:     &RoleStore{db},
+		Post:         &PostStore{db},
+		User:         &UserStore{db},
+		Comment:      &CommentStore{db},
+		Follower:     &FollowerStore{db},
+		Role:         &RoleStore{db},
+		RefreshToken: &RefreshTokenStore{db},
+		APIKey:       &APIKeyStore{db},
+		Session:      &SessionStore{db},
+		Identity:     &IdentityStore{db},
+		Block:        &BlockStore{db},
+		Reaction:     &ReactionStore{db},
+		Mute:         &MuteStore{db},
 	}
 }
 
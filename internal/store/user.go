@@ -6,8 +6,12 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,11 +19,59 @@ type User struct {
 	Id        int64        `json:"id"`
 	Username  string       `json:"username"`
 	Email     string       `json:"email"`
-	Password  PasswordType `json:"password"`
+	Password  PasswordType `json:"-"`
 	CreatedAt string       `json:"created_at"`
 	IsActive  bool         `json:"is_active"`
 	Role      Role         `json:"role"`
 	RoleId    int64        `json:"role_id"`
+	// TOTPSecret is needed to check codes so it is kept as is, never send it
+	// back after enrollment
+	TOTPSecret  string     `json:"-"`
+	TOTPEnabled bool       `json:"totp_enabled"`
+	LockedUntil *time.Time `json:"locked_until"`
+	Profile
+	Counts
+}
+
+// Counts are kept up to date by triggers on followers and posts.
+type Counts struct {
+	FollowerCount  int64 `json:"follower_count"`
+	FollowingCount int64 `json:"following_count"`
+	PostCount      int64 `json:"post_count"`
+}
+
+// Profile is the part of a user anyone can see and the user can edit.
+type Profile struct {
+	DisplayName  string   `json:"display_name"`
+	Bio          string   `json:"bio"`
+	Location     string   `json:"location"`
+	WebsiteLinks []string `json:"website_links"`
+	AvatarURL    string   `json:"avatar_url"`
+	// IsPrivate hides posts from everyone but approved followers
+	IsPrivate bool `json:"is_private"`
+}
+
+// Author is the part of a user shown next to their posts and comments.
+type Author struct {
+	Id          int64  `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// profileColumns are the columns of the users row u that a public profile
+// shows, in the order of profileFields.
+const profileColumns = `u.id,u.username,u.created_at,u.display_name,u.bio,u.location,u.website_links,u.avatar_url,u.is_private,
+			u.follower_count,u.following_count,u.post_count`
+
+// profileFields are the scan destinations of profileColumns.
+func profileFields(user *User) []any {
+	return []any{&user.Id, &user.Username, &user.CreatedAt, &user.DisplayName, &user.Bio, &user.Location, pq.Array(&user.WebsiteLinks), &user.AvatarURL, &user.IsPrivate,
+		&user.FollowerCount, &user.FollowingCount, &user.PostCount}
+}
+
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
 }
 
 type PasswordType struct {
@@ -64,12 +116,63 @@ func (u *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+// CreateWithIdentity signs up a user coming from an identity provider. The
+// account starts out active when user.IsActive is set, which is only right
+// once the provider verified the email, otherwise it gets an invitation like
+// CreateAndInvite does. The username gets a numeric suffix when the one
+// asked for is taken.
+func (u *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity, token string, exp time.Duration) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		username, err := u.freeUsername(ctx, tx, user.Username)
+		if err != nil {
+			return err
+		}
+		user.Username = username
+		if err := u.Create(ctx, tx, user); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+		if user.IsActive {
+			if err := u.update(ctx, tx, user); err != nil {
+				return err
+			}
+		} else if err := u.createUserInvitation(ctx, tx, token, exp, user.Id); err != nil {
+			return err
+		}
+		identity.UserId = user.Id
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+func (u *UserStore) freeUsername(ctx context.Context, tx *sql.Tx, username string) (string, error) {
+	candidate := username
+	for range 5 {
+		var taken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE username=$1)`
+		if err := tx.QueryRowContext(ctx, query, candidate).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", username, 1000+rand.IntN(9000))
+	}
+	return "", ErrConflict
+}
+
 func (u *UserStore) GetById(ctx context.Context, userId int64) (*User, error) {
-	query := `SELECT a.id,a.username,a.email,a.password,a.created_at,b.id,b.name,b.description,b.level FROM users a JOIN roles b ON a.role_id=b.id WHERE a.id=$1`
+	query := `SELECT a.id,a.username,a.email,a.password,a.created_at,a.is_active,COALESCE(a.totp_secret,''),a.totp_enabled,a.locked_until,
+			a.display_name,a.bio,a.location,a.website_links,a.avatar_url,a.is_private,a.follower_count,a.following_count,a.post_count,
+			b.id,b.name,b.description,b.level,b.requires_mfa
+			FROM users a JOIN roles b ON a.role_id=b.id WHERE a.id=$1`
 	user := &User{}
 	err := u.db.
 		QueryRowContext(ctx, query, userId).
-		Scan(&user.Id, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Role.Id, &user.Role.Name, &user.Role.Description, &user.Role.Level)
+		Scan(&user.Id, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.IsActive, &user.TOTPSecret, &user.TOTPEnabled, &user.LockedUntil,
+			&user.DisplayName, &user.Bio, &user.Location, pq.Array(&user.WebsiteLinks), &user.AvatarURL, &user.IsPrivate, &user.FollowerCount, &user.FollowingCount, &user.PostCount,
+			&user.Role.Id, &user.Role.Name, &user.Role.Description, &user.Role.Level, &user.Role.RequiresMFA)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -124,6 +227,56 @@ func (u *UserStore) Activate(ctx context.Context, token string) error {
 	})
 }
 
+// RegenerateInvitation replaces the invitation of a not yet activated user
+// with a fresh token.
+func (u *UserStore) RegenerateInvitation(ctx context.Context, email string, token string, exp time.Duration) (*User, error) {
+	user := &User{}
+	err := WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT id,username,email,created_at FROM users WHERE email=$1 AND is_active=false`
+		err := tx.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email, &user.CreatedAt)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if err := u.deleteUserInvitation(ctx, tx, user.Id); err != nil {
+			return err
+		}
+		return u.createUserInvitation(ctx, tx, token, exp, user.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (u *UserStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitations WHERE expiry<=$1`
+	res, err := u.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteUnactivated removes users that never activated their account within
+// maxAge so their username and email can be registered again. Users holding
+// a still valid invitation are kept.
+func (u *UserStore) DeleteUnactivated(ctx context.Context, maxAge time.Duration) (int64, error) {
+	query := `DELETE FROM users a
+			WHERE a.is_active=false AND a.created_at<$1
+			AND NOT EXISTS (SELECT 1 FROM user_invitations b WHERE b.user_id=a.id AND b.expiry>$2)`
+	now := time.Now()
+	res, err := u.db.ExecContext(ctx, query, now.Add(-maxAge), now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (u *UserStore) findUserFromInvitation(ctx context.Context, tx *sql.Tx, token string) (*User, error) {
 	query := `SELECT a.id,a.username,a.email,a.is_active,a.created_at FROM
 			users a JOIN user_invitations b
@@ -147,9 +300,12 @@ func (u *UserStore) findUserFromInvitation(ctx context.Context, tx *sql.Tx, toke
 }
 
 func (u *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
-	query := `SELECT id,email,username,created_at,password FROM users where email=$1 AND is_active=true`
-	user := &User{}
-	err := u.db.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Email, &user.Username, &user.CreatedAt, &user.Password.hash)
+	query := `SELECT a.id,a.email,a.username,a.created_at,a.password,a.totp_enabled,a.locked_until,
+			b.id,b.name,b.description,b.level,b.requires_mfa
+			FROM users a JOIN roles b ON a.role_id=b.id WHERE a.email=$1 AND a.is_active=true`
+	user := &User{IsActive: true}
+	err := u.db.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Email, &user.Username, &user.CreatedAt, &user.Password.hash, &user.TOTPEnabled, &user.LockedUntil,
+		&user.Role.Id, &user.Role.Name, &user.Role.Description, &user.Role.Level, &user.Role.RequiresMFA)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -165,6 +321,9 @@ func (u *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `UPDATE users SET username=$1,email=$2,is_active=$3 WHERE id=$4`
 	_, err := tx.ExecContext(ctx, query, user.Username, user.Email, user.IsActive, user.Id)
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrConflict
+		}
 		return err
 	}
 	return nil
@@ -199,3 +358,333 @@ func (u *UserStore) Delete(ctx context.Context, userId int64) error {
 		return nil
 	})
 }
+
+func (u *UserStore) CreatePasswordReset(ctx context.Context, userId int64, token string, exp time.Duration) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		// only the latest requested link stays valid
+		if err := u.deletePasswordResets(ctx, tx, userId); err != nil {
+			return err
+		}
+		query := `INSERT INTO password_resets (token,user_id,expiry) VALUES($1,$2,$3)`
+		_, err := tx.ExecContext(ctx, query, token, userId, time.Now().Add(exp))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// ResetPassword stores the new password of the user owning the reset token
+// and signs the user out of every existing session.
+func (u *UserStore) ResetPassword(ctx context.Context, token string, user *User) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT user_id FROM password_resets WHERE token=$1 AND expiry>$2`
+		err := tx.QueryRowContext(ctx, query, token, time.Now()).Scan(&user.Id)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if err := u.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+		if err := u.deletePasswordResets(ctx, tx, user.Id); err != nil {
+			return err
+		}
+		return revokeUserSessions(ctx, tx, user.Id)
+	})
+}
+
+func (u *UserStore) updatePassword(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `UPDATE users SET password=$1 WHERE id=$2`
+	_, err := tx.ExecContext(ctx, query, user.Password.hash, user.Id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userId int64) error {
+	query := `DELETE FROM password_resets WHERE user_id=$1`
+	_, err := tx.ExecContext(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetTOTPSecret starts a new enrollment, two factor stays disabled until a
+// code for the secret is confirmed with EnableTOTP.
+func (u *UserStore) SetTOTPSecret(ctx context.Context, userId int64, secret string) error {
+	query := `UPDATE users SET totp_secret=$1,totp_enabled=false,totp_last_step=0 WHERE id=$2`
+	_, err := u.db.ExecContext(ctx, query, secret, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the time step of the last two factor code
+// userId used, ErrConflict means a code of that step or a later one was
+// already used.
+func (u *UserStore) UseTOTPStep(ctx context.Context, userId int64, step int64) error {
+	query := `UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step<$1`
+	res, err := u.db.ExecContext(ctx, query, step, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (u *UserStore) EnableTOTP(ctx context.Context, userId int64, recoveryCodes []string) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET totp_enabled=true WHERE id=$1`
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			return err
+		}
+		return u.replaceRecoveryCodes(ctx, tx, userId, recoveryCodes)
+	})
+}
+
+func (u *UserStore) DisableTOTP(ctx context.Context, userId int64) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET totp_secret=NULL,totp_enabled=false WHERE id=$1`
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			return err
+		}
+		return u.replaceRecoveryCodes(ctx, tx, userId, nil)
+	})
+}
+
+func (u *UserStore) ReplaceRecoveryCodes(ctx context.Context, userId int64, recoveryCodes []string) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		return u.replaceRecoveryCodes(ctx, tx, userId, recoveryCodes)
+	})
+}
+
+// UseRecoveryCode burns a hashed recovery code, ErrorNotFound means the code
+// is unknown or was already used.
+func (u *UserStore) UseRecoveryCode(ctx context.Context, userId int64, code string) error {
+	query := `UPDATE user_recovery_codes SET used_at=NOW() WHERE user_id=$1 AND code=$2 AND used_at IS NULL`
+	res, err := u.db.ExecContext(ctx, query, userId, code)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (u *UserStore) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int64, recoveryCodes []string) error {
+	query := `DELETE FROM user_recovery_codes WHERE user_id=$1`
+	if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+		return err
+	}
+	query = `INSERT INTO user_recovery_codes (user_id,code) VALUES($1,$2)`
+	for _, code := range recoveryCodes {
+		if _, err := tx.ExecContext(ctx, query, userId, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *UserStore) Lock(ctx context.Context, userId int64, until time.Time) error {
+	query := `UPDATE users SET locked_until=$1 WHERE id=$2`
+	_, err := u.db.ExecContext(ctx, query, until, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (u *UserStore) Unlock(ctx context.Context, userId int64) error {
+	query := `UPDATE users SET locked_until=NULL WHERE id=$1`
+	res, err := u.db.ExecContext(ctx, query, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (u *UserStore) UpdateProfile(ctx context.Context, userId int64, profile *Profile) error {
+	query := `UPDATE users SET display_name=$1,bio=$2,location=$3,website_links=$4,avatar_url=$5,is_private=$6 WHERE id=$7`
+	res, err := u.db.ExecContext(ctx, query, profile.DisplayName, profile.Bio, profile.Location, pq.Array(profile.WebsiteLinks), profile.AvatarURL, profile.IsPrivate, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (u *UserStore) UpdateRole(ctx context.Context, userId int64, roleId int64) error {
+	query := `UPDATE users SET role_id=$1 WHERE id=$2`
+	res, err := u.db.ExecContext(ctx, query, roleId, userId)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// CreateEmailChange keeps newEmail pending until the token mailed to it is
+// confirmed. Only the latest request of a user stays valid.
+func (u *UserStore) CreateEmailChange(ctx context.Context, userId int64, newEmail string, token string, exp time.Duration) error {
+	return WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		var taken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE email=$1)`
+		if err := tx.QueryRowContext(ctx, query, newEmail).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrConflict
+		}
+		query = `DELETE FROM email_changes WHERE user_id=$1`
+		if _, err := tx.ExecContext(ctx, query, userId); err != nil {
+			return err
+		}
+		query = `INSERT INTO email_changes (token,user_id,new_email,expiry) VALUES($1,$2,$3,$4)`
+		_, err := tx.ExecContext(ctx, query, token, userId, newEmail, time.Now().Add(exp))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// ConfirmEmailChange swaps in the pending email for the token. ErrConflict
+// means someone else took the address since the change was requested.
+func (u *UserStore) ConfirmEmailChange(ctx context.Context, token string) (*User, error) {
+	user := &User{}
+	err := WithTx(u.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT a.id,a.username,a.is_active,b.new_email FROM
+				users a JOIN email_changes b
+				ON a.id=b.user_id
+				WHERE b.token=$1 AND b.expiry>$2`
+		err := tx.QueryRowContext(ctx, query, token, time.Now()).Scan(&user.Id, &user.Username, &user.IsActive, &user.Email)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if err := u.update(ctx, tx, user); err != nil {
+			return err
+		}
+		query = `DELETE FROM email_changes WHERE user_id=$1`
+		_, err = tx.ExecContext(ctx, query, user.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UserSearchResult is a user matching a search, as seen by the searcher.
+type UserSearchResult struct {
+	User
+	IsFollowedByMe bool
+}
+
+type userSearchCursor struct {
+	Score     string `json:"s"`
+	Followers int64  `json:"f"`
+	Id        int64  `json:"i"`
+}
+
+// Search finds active users whose username or display name starts with or
+// looks like q.Query. Prefix matches rank first, then similarity and follower
+// count. Users blocking or blocked by viewerId never show up. The returned
+// cursor is empty on the last page.
+func (u *UserStore) Search(ctx context.Context, viewerId int64, q UserSearchQuery) ([]UserSearchResult, string, error) {
+	args := []any{likePrefix(q.Query), q.Query, viewerId}
+	after := ""
+	if q.Cursor != "" {
+		var cursor userSearchCursor
+		if err := DecodeCursor(q.Cursor, &cursor); err != nil {
+			return nil, "", err
+		}
+		args = append(args, cursor.Score, cursor.Followers, cursor.Id)
+		after = `WHERE m.score<$4 OR (m.score=$4 AND (m.follower_count<$5 OR (m.follower_count=$5 AND m.id>$6)))`
+	}
+	// one more row than asked for tells whether there is a next page
+	args = append(args, q.Limit+1)
+
+	query := `WITH matches AS (
+				SELECT u.id,u.username,u.created_at,u.display_name,u.bio,u.location,u.website_links,u.avatar_url,u.is_private,
+				u.follower_count,u.following_count,u.post_count,
+				ROUND((CASE WHEN u.username ILIKE $1 OR u.display_name ILIKE $1 THEN 1 ELSE 0 END
+					+ GREATEST(similarity(u.username,$2),similarity(u.display_name,$2)))::numeric,4) AS score
+				FROM users u
+				WHERE u.is_active=true
+				AND (u.username ILIKE $1 OR u.display_name ILIKE $1 OR u.username % $2 OR u.display_name % $2)
+				AND NOT ` + blockedClause("u.id", "$3") + `
+			)
+			SELECT m.id,m.username,m.created_at,m.display_name,m.bio,m.location,m.website_links,m.avatar_url,m.is_private,
+			m.follower_count,m.following_count,m.post_count,m.score,
+			EXISTS (SELECT 1 FROM followers f WHERE f.user_id=$3 AND f.follower_id=m.id)
+			FROM matches m ` + after + `
+			ORDER BY m.score DESC,m.follower_count DESC,m.id ASC
+			LIMIT $` + strconv.Itoa(len(args))
+	rows, err := u.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	results := []UserSearchResult{}
+	var last userSearchCursor
+	for rows.Next() {
+		var res UserSearchResult
+		var score string
+		err := rows.Scan(&res.Id, &res.Username, &res.CreatedAt, &res.DisplayName, &res.Bio, &res.Location, pq.Array(&res.WebsiteLinks), &res.AvatarURL, &res.IsPrivate,
+			&res.FollowerCount, &res.FollowingCount, &res.PostCount, &score, &res.IsFollowedByMe)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(results) == q.Limit {
+			next, err := EncodeCursor(last)
+			return results, next, err
+		}
+		results = append(results, res)
+		last = userSearchCursor{Score: score, Followers: res.FollowerCount, Id: res.Id}
+	}
+	return results, "", rows.Err()
+}
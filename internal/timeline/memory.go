@@ -0,0 +1,146 @@
+package timeline
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+type memoryTimeline struct {
+	posts []store.FeedPosition
+	// complete is false once older posts were trimmed
+	complete bool
+	expiry   time.Time
+}
+
+// MemoryTimeline keeps timelines in process. Each instance has its own, so
+// it is meant for tests and running without redis.
+type MemoryTimeline struct {
+	sync.Mutex
+	timelines map[int64]*memoryTimeline
+	maxLength int
+	expiry    time.Duration
+}
+
+func NewMemoryTimeline(maxLength int, expiry time.Duration) *MemoryTimeline {
+	return &MemoryTimeline{
+		timelines: make(map[int64]*memoryTimeline),
+		maxLength: maxLength,
+		expiry:    expiry,
+	}
+}
+
+// get returns the cached timeline of userId, dropping it when it expired.
+// The caller holds the lock.
+func (m *MemoryTimeline) get(userId int64) *memoryTimeline {
+	t, ok := m.timelines[userId]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(t.expiry) {
+		delete(m.timelines, userId)
+		return nil
+	}
+	return t
+}
+
+func (m *MemoryTimeline) Push(ctx context.Context, post store.FeedPosition, userIds []int64) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, userId := range userIds {
+		t := m.get(userId)
+		if t == nil {
+			continue
+		}
+		i, found := slices.BinarySearchFunc(t.posts, post, func(a, b store.FeedPosition) int {
+			switch {
+			case a.Id == b.Id:
+				return 0
+			case before(a, b):
+				return -1
+			default:
+				return 1
+			}
+		})
+		if found {
+			continue
+		}
+		t.posts = slices.Insert(t.posts, i, post)
+		if len(t.posts) > m.maxLength {
+			t.posts = t.posts[:m.maxLength]
+			t.complete = false
+		}
+	}
+	return nil
+}
+
+func (m *MemoryTimeline) Fill(ctx context.Context, userId int64, posts []store.FeedPosition) error {
+	m.Lock()
+	defer m.Unlock()
+	sorted := slices.Clone(posts)
+	slices.SortFunc(sorted, func(a, b store.FeedPosition) int {
+		if before(a, b) {
+			return -1
+		}
+		return 1
+	})
+	complete := len(sorted) < m.maxLength
+	if !complete {
+		sorted = sorted[:m.maxLength]
+	}
+	m.timelines[userId] = &memoryTimeline{posts: sorted, complete: complete, expiry: time.Now().Add(m.expiry)}
+	return nil
+}
+
+func (m *MemoryTimeline) Page(ctx context.Context, userId int64, after *store.FeedPosition, limit int) ([]int64, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	t := m.get(userId)
+	if t == nil {
+		return nil, false, nil
+	}
+	t.expiry = time.Now().Add(m.expiry)
+
+	ids := []int64{}
+	for _, post := range t.posts {
+		if after != nil && !before(*after, post) {
+			continue
+		}
+		if len(ids) == limit {
+			break
+		}
+		ids = append(ids, post.Id)
+	}
+	if len(ids) < limit && !t.complete {
+		return nil, false, nil
+	}
+	return ids, true, nil
+}
+
+func (m *MemoryTimeline) Remove(ctx context.Context, postIds []int64, userIds []int64) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, userId := range userIds {
+		t := m.get(userId)
+		if t == nil {
+			continue
+		}
+		t.posts = slices.DeleteFunc(t.posts, func(post store.FeedPosition) bool {
+			return slices.Contains(postIds, post.Id)
+		})
+		if len(t.posts) == 0 && !t.complete {
+			delete(m.timelines, userId)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryTimeline) Invalidate(ctx context.Context, userId int64) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.timelines, userId)
+	return nil
+}
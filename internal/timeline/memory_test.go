@@ -0,0 +1,196 @@
+package timeline
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// at is the post id at the given second after epoch.
+func at(id int64, second int) store.FeedPosition {
+	return store.FeedPosition{CreatedAt: epoch.Add(time.Duration(second) * time.Second), Id: id}
+}
+
+func TestMemoryTimelinePage(t *testing.T) {
+	const userId = 1
+	tests := []struct {
+		name      string
+		maxLength int
+		fill      []store.FeedPosition
+		push      []store.FeedPosition
+		after     *store.FeedPosition
+		limit     int
+		wantIds   []int64
+		wantOk    bool
+	}{
+		{
+			name:      "cold timeline",
+			maxLength: 10,
+			limit:     5,
+			wantOk:    false,
+		},
+		{
+			name:      "fill sorts newest first",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(3, 3), at(2, 2)},
+			limit:     5,
+			wantIds:   []int64{3, 2, 1},
+			wantOk:    true,
+		},
+		{
+			name:      "same second sorts by id",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(4, 1), at(2, 1)},
+			limit:     5,
+			wantIds:   []int64{4, 2, 1},
+			wantOk:    true,
+		},
+		{
+			name:      "push inserts in order",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(3, 3)},
+			push:      []store.FeedPosition{at(2, 2), at(4, 4)},
+			limit:     5,
+			wantIds:   []int64{4, 3, 2, 1},
+			wantOk:    true,
+		},
+		{
+			name:      "push ignores posts already there",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2)},
+			push:      []store.FeedPosition{at(2, 2)},
+			limit:     5,
+			wantIds:   []int64{2, 1},
+			wantOk:    true,
+		},
+		{
+			name:      "page after cursor",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2), at(3, 3), at(4, 4), at(5, 5)},
+			after:     &store.FeedPosition{CreatedAt: epoch.Add(4 * time.Second), Id: 4},
+			limit:     2,
+			wantIds:   []int64{3, 2},
+			wantOk:    true,
+		},
+		{
+			name:      "page after cursor within the same second",
+			maxLength: 10,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2), at(3, 2), at(4, 2)},
+			after:     &store.FeedPosition{CreatedAt: epoch.Add(2 * time.Second), Id: 3},
+			limit:     5,
+			wantIds:   []int64{2, 1},
+			wantOk:    true,
+		},
+		{
+			name:      "fill at max length trims the oldest",
+			maxLength: 3,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2), at(3, 3), at(4, 4)},
+			limit:     3,
+			wantIds:   []int64{4, 3, 2},
+			wantOk:    true,
+		},
+		{
+			name:      "trimmed timeline can't serve past its end",
+			maxLength: 3,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2), at(3, 3), at(4, 4)},
+			limit:     4,
+			wantOk:    false,
+		},
+		{
+			name:      "push past max length trims the oldest",
+			maxLength: 3,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2)},
+			push:      []store.FeedPosition{at(3, 3), at(4, 4)},
+			limit:     3,
+			wantIds:   []int64{4, 3, 2},
+			wantOk:    true,
+		},
+		{
+			name:      "push past max length drops the end marker",
+			maxLength: 3,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2)},
+			push:      []store.FeedPosition{at(3, 3), at(4, 4)},
+			after:     &store.FeedPosition{CreatedAt: epoch.Add(3 * time.Second), Id: 3},
+			limit:     2,
+			wantOk:    false,
+		},
+		{
+			name:      "complete timeline serves a short last page",
+			maxLength: 3,
+			fill:      []store.FeedPosition{at(1, 1), at(2, 2)},
+			push:      []store.FeedPosition{at(3, 3)},
+			after:     &store.FeedPosition{CreatedAt: epoch.Add(2 * time.Second), Id: 2},
+			limit:     2,
+			wantIds:   []int64{1},
+			wantOk:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMemoryTimeline(tt.maxLength, time.Hour)
+			if tt.fill != nil {
+				if err := m.Fill(ctx, userId, tt.fill); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, post := range tt.push {
+				if err := m.Push(ctx, post, []int64{userId}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ids, ok, err := m.Page(ctx, userId, tt.after, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOk)
+			}
+			if ok && !slices.Equal(ids, tt.wantIds) {
+				t.Fatalf("ids = %v, want %v", ids, tt.wantIds)
+			}
+		})
+	}
+}
+
+func TestMemoryTimelineRemove(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryTimeline(3, time.Hour)
+	if err := m.Fill(ctx, 1, []store.FeedPosition{at(1, 1), at(2, 2), at(3, 3), at(4, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove(ctx, []int64{3}, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	ids, ok, err := m.Page(ctx, 1, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !slices.Equal(ids, []int64{4, 2}) {
+		t.Fatalf("ids = %v, ok = %v, want [4 2], true", ids, ok)
+	}
+
+	// emptying a trimmed timeline forgets it, so it gets filled again
+	if err := m.Remove(ctx, []int64{2, 4}, []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := m.Page(ctx, 1, nil, 1); ok {
+		t.Fatal("expected an emptied trimmed timeline to be cold")
+	}
+}
+
+func TestMemoryTimelineExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryTimeline(10, -time.Second)
+	if err := m.Fill(ctx, 1, []store.FeedPosition{at(1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := m.Page(ctx, 1, nil, 1); ok {
+		t.Fatal("expected an expired timeline to be cold")
+	}
+}
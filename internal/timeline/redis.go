@@ -0,0 +1,164 @@
+package timeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+// pushBatch caps how many timelines one push script touches.
+const pushBatch = 500
+
+// pushScript adds ARGV[2] with score ARGV[1] to the timelines in KEYS that
+// exist, trimming them to ARGV[3] entries.
+var pushScript = redis.NewScript(`
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		redis.call('ZADD', key, ARGV[1], ARGV[2])
+		redis.call('ZREMRANGEBYRANK', key, 0, -tonumber(ARGV[3]) - 1)
+	end
+end
+return 0
+`)
+
+// endMember marks a timeline that holds every post there is. Its score sorts
+// it last, so it is the first thing trimmed away.
+const endMember = "end"
+
+// RedisTimeline keeps each timeline in a sorted set scored by the post's
+// created_at in seconds. Members are zero padded ids, so posts created in
+// the same second sort by id.
+type RedisTimeline struct {
+	rdb       *redis.Client
+	maxLength int
+	expiry    time.Duration
+}
+
+func NewRedisTimeline(rdb *redis.Client, maxLength int, expiry time.Duration) *RedisTimeline {
+	return &RedisTimeline{rdb: rdb, maxLength: maxLength, expiry: expiry}
+}
+
+func timelineKey(userId int64) string {
+	return fmt.Sprintf("timeline-%v", userId)
+}
+
+func member(postId int64) string {
+	return fmt.Sprintf("%019d", postId)
+}
+
+func score(post store.FeedPosition) string {
+	return strconv.FormatInt(post.CreatedAt.Unix(), 10)
+}
+
+func (t *RedisTimeline) Push(ctx context.Context, post store.FeedPosition, userIds []int64) error {
+	for start := 0; start < len(userIds); start += pushBatch {
+		end := min(start+pushBatch, len(userIds))
+		keys := make([]string, 0, end-start)
+		for _, userId := range userIds[start:end] {
+			keys = append(keys, timelineKey(userId))
+		}
+		if err := pushScript.Run(ctx, t.rdb, keys, score(post), member(post.Id), t.maxLength).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *RedisTimeline) Fill(ctx context.Context, userId int64, posts []store.FeedPosition) error {
+	key := timelineKey(userId)
+	_, err := t.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		members := make([]*redis.Z, 0, len(posts)+1)
+		for _, post := range posts {
+			members = append(members, &redis.Z{Score: float64(post.CreatedAt.Unix()), Member: member(post.Id)})
+		}
+		if len(posts) < t.maxLength {
+			members = append(members, &redis.Z{Score: math.Inf(-1), Member: endMember})
+		}
+		pipe.ZAdd(ctx, key, members...)
+		pipe.ZRemRangeByRank(ctx, key, 0, -int64(t.maxLength)-1)
+		pipe.Expire(ctx, key, t.expiry)
+		return nil
+	})
+	return err
+}
+
+func (t *RedisTimeline) Page(ctx context.Context, userId int64, after *store.FeedPosition, limit int) ([]int64, bool, error) {
+	key := timelineKey(userId)
+	pipe := t.rdb.Pipeline()
+	exists := pipe.Exists(ctx, key)
+	// posts in the same second as the position are only partly on this page
+	var sameSecond *redis.StringSliceCmd
+	max := "+inf"
+	if after != nil {
+		sameSecond = pipe.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{Min: score(*after), Max: score(*after)})
+		max = "(" + score(*after)
+	}
+	older := pipe.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: max, Count: int64(limit)})
+	pipe.Expire(ctx, key, t.expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, err
+	}
+	if exists.Val() == 0 {
+		return nil, false, nil
+	}
+
+	var members []string
+	if sameSecond != nil {
+		for _, m := range sameSecond.Val() {
+			if m < member(after.Id) {
+				members = append(members, m)
+			}
+		}
+	}
+	complete := false
+	for _, m := range older.Val() {
+		if m == endMember {
+			complete = true
+			break
+		}
+		members = append(members, m)
+	}
+	if len(members) > limit {
+		members = members[:limit]
+	}
+	if len(members) < limit && !complete {
+		return nil, false, nil
+	}
+
+	ids := make([]int64, len(members))
+	for i, m := range members {
+		id, err := strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			return nil, false, err
+		}
+		ids[i] = id
+	}
+	return ids, true, nil
+}
+
+func (t *RedisTimeline) Remove(ctx context.Context, postIds []int64, userIds []int64) error {
+	if len(postIds) == 0 {
+		return nil
+	}
+	members := make([]any, len(postIds))
+	for i, postId := range postIds {
+		members[i] = member(postId)
+	}
+	_, err := t.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, userId := range userIds {
+			pipe.ZRem(ctx, timelineKey(userId), members...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (t *RedisTimeline) Invalidate(ctx context.Context, userId int64) error {
+	return t.rdb.Del(ctx, timelineKey(userId)).Err()
+}
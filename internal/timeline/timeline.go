@@ -0,0 +1,55 @@
+package timeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/samualhalder/go-social/internal/store"
+)
+
+// Timeline keeps the post ids of each user's home timeline, newest first, in
+// the feed's (created_at, id) order. Only timelines that were filled are kept
+// up to date, the feed falls back to SQL for the others.
+type Timeline interface {
+	// Push adds a new post to the cached timelines among userIds.
+	Push(ctx context.Context, post store.FeedPosition, userIds []int64) error
+	// Fill replaces the timeline of userId. posts shorter than the maximum
+	// length are taken to be all the posts there are.
+	Fill(ctx context.Context, userId int64, posts []store.FeedPosition) error
+	// Page returns up to limit post ids after the given position, from the
+	// start when it is nil. ok is false when the timeline isn't cached, or
+	// runs out before limit ids after older posts were trimmed from it.
+	Page(ctx context.Context, userId int64, after *store.FeedPosition, limit int) (ids []int64, ok bool, err error)
+	// Remove drops posts from the timelines of userIds.
+	Remove(ctx context.Context, postIds []int64, userIds []int64) error
+	// Invalidate forgets the timeline of userId so it is filled again.
+	Invalidate(ctx context.Context, userId int64) error
+}
+
+type Config struct {
+	Enabled bool
+	// MaxLength is how many posts a timeline keeps, older ones come from SQL.
+	MaxLength int
+	// CelebrityThreshold is the follower count above which posts aren't
+	// pushed to followers, their feeds query them instead.
+	CelebrityThreshold int64
+	// Expiry is how long the timeline of an inactive user is kept.
+	Expiry time.Duration
+}
+
+// New keeps timelines in redis, or in process when redis is disabled.
+func New(rdb *redis.Client, cfg Config) Timeline {
+	if rdb == nil {
+		return NewMemoryTimeline(cfg.MaxLength, cfg.Expiry)
+	}
+	return NewRedisTimeline(rdb, cfg.MaxLength, cfg.Expiry)
+}
+
+// before tells whether a comes before b in a timeline.
+func before(a, b store.FeedPosition) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	return a.Id > b.Id
+}